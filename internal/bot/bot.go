@@ -1,7 +1,12 @@
 package bot
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/uaru-shit/votes/internal/bot/handlers"
 	"github.com/uaru-shit/votes/internal/domain"
@@ -9,67 +14,200 @@ import (
 	tb "gopkg.in/telebot.v4"
 )
 
+// persistent stores the bot works with
+type Storage struct {
+	Polls    domain.PollStorage
+	History  domain.PollHistory
+	Members  domain.MemberStorage
+	Settings domain.SettingsStorage
+}
+
+// closes the stores that hold resources or buffer writes
+func (s Storage) Close() error {
+	var errs []error
+	// members and history may share the database of polls, so polls go last
+	for _, store := range []any{s.Settings, s.Members, s.History, s.Polls} {
+		if closer, ok := store.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
 type Bot struct {
-	bot           *tb.Bot
+	bot *tb.Bot
+	// rate limited client every service and handler talks to telegram through
+	api           tb.API
 	logger        *slog.Logger
 	pollStorage   domain.PollStorage
+	pollHistory   domain.PollHistory
+	memberStorage domain.MemberStorage
 	pollMonitor   *services.PollMonitorService
+	adminCache    *services.AdminCacheService
+	access        *services.AccessService
+	pollGuard     *services.PollGuardService
+	config        *services.ConfigService
+	pollKinds     *domain.PollKinds
+	scheduler     *services.Scheduler
 	messageFilter *services.MessageFilterService
+
+	// handlers run in goroutines of their own, Start waits for them
+	// before returning so none of them outlives the storage
+	handlersMutex   sync.Mutex
+	handlersStopped bool
+	handlers        sync.WaitGroup
 }
 
-func New(logger *slog.Logger, bot *tb.Bot, pollStorage domain.PollStorage) *Bot {
-	permissionService := services.NewPermissionService(bot, logger)
-	pollProcessor := services.NewPollProcessorService(bot, logger, permissionService)
-	pollMonitor := services.NewPollMonitorService(bot, logger, pollStorage, pollProcessor)
-	messageFilter := services.NewMessageFilterService(bot, logger)
+// number of polls processed at the same time
+const schedulerWorkers = 4
+
+func New(logger *slog.Logger, bot *tb.Bot, storage Storage) *Bot {
+	api := services.NewRateLimitedAPI(bot, services.NewRateLimiter(logger))
+
+	config := services.NewConfigService(storage.Settings, logger)
+	permissionService := services.NewPermissionService(api, logger, storage.History)
+	pollKinds := services.NewPollKinds(api, logger, permissionService)
+	quorumService := services.NewQuorumService(api, logger, pollKinds, config)
+	eligibilityService := services.NewEligibilityService(storage.Members, logger)
+	pollProcessor := services.NewPollProcessorService(api, logger, pollKinds, quorumService, eligibilityService)
+	scheduler := services.NewScheduler(logger, schedulerWorkers)
+	pollMonitor := services.NewPollMonitorService(api, logger, storage.Polls, storage.History, pollProcessor, quorumService, eligibilityService, scheduler, services.NewRetryPolicy(logger))
+	// deletions are the first to go when telegram is busy
+	messageFilter := services.NewMessageFilterService(api.LowPriority(), logger, config)
+	adminCache := services.NewAdminCacheService(api, logger)
+	access := services.NewAccessService(api, logger, adminCache, config)
+	pollGuard := services.NewPollGuardService(storage.Polls, storage.History, config, logger)
 
 	b := &Bot{
 		bot:           bot,
+		api:           api,
 		logger:        logger,
-		pollStorage:   pollStorage,
+		pollStorage:   storage.Polls,
+		pollHistory:   storage.History,
+		memberStorage: storage.Members,
 		pollMonitor:   pollMonitor,
+		adminCache:    adminCache,
+		access:        access,
+		pollGuard:     pollGuard,
+		config:        config,
+		pollKinds:     pollKinds,
+		scheduler:     scheduler,
 		messageFilter: messageFilter,
 	}
 
 	b.setupHandlers()
-	go pollMonitor.RestoreActivePolls()
 
 	return b
 }
 
 func (b *Bot) setupHandlers() {
-	b.handle("/voteban", handlers.HandleVoteban)
-	b.handle("/vote", handlers.HandleVoteban)
-	b.handle("/ban", handlers.HandleVoteban)
+	for _, kind := range b.pollKinds.All() {
+		for _, command := range kind.Commands() {
+			b.handle("/"+command, domain.CommandVote, handlers.HandleVote(kind))
+		}
+	}
+
+	b.handle("/instaban", domain.CommandAdmin, handlers.HandleInstaban)
+
+	b.handle("/polls", domain.CommandPublic, handlers.HandlePolls)
+
+	b.handle("/history", domain.CommandAdmin, handlers.HandleHistory)
+	b.handle(&tb.Btn{Unique: handlers.HistoryButtonUnique}, domain.CommandAdmin, handlers.HandleHistoryPage)
+
+	b.handle("/failed", domain.CommandAdmin, handlers.HandleFailed)
+	b.handle(&tb.Btn{Unique: handlers.FailedRetryButtonUnique}, domain.CommandAdmin, handlers.HandleFailedRetry)
+	b.handle(&tb.Btn{Unique: handlers.FailedDropButtonUnique}, domain.CommandAdmin, handlers.HandleFailedDrop)
+
+	// checks admin rights itself, a member just promoted is missing from the cached list
+	b.handle("/refreshadmins", domain.CommandPublic, handlers.HandleRefreshAdmins)
+
+	b.handle("/settings", domain.CommandAdmin, handlers.HandleSettings)
+	b.handle(&tb.Btn{Unique: handlers.SettingsButtonUnique}, domain.CommandAdmin, handlers.HandleSettingsButton)
 
-	b.handle("/voteunban", handlers.HandleVoteUnban)
-	b.handle("/unban", handlers.HandleVoteUnban)
+	b.handle("/help", domain.CommandPublic, handlers.HandleHelp)
 
-	b.handle("/instaban", handlers.HandleInstaban)
+	b.bot.Handle(tb.OnText, b.tracked(b.handleAllMessages))
+	b.bot.Handle(tb.OnPhoto, b.tracked(b.handleAllMessages))
+	b.bot.Handle(tb.OnVideo, b.tracked(b.handleAllMessages))
+	b.bot.Handle(tb.OnDocument, b.tracked(b.handleAllMessages))
+	b.bot.Handle(tb.OnAudio, b.tracked(b.handleAllMessages))
+	b.bot.Handle(tb.OnVoice, b.tracked(b.handleAllMessages))
+	b.bot.Handle(tb.OnSticker, b.tracked(b.handleAllMessages))
 
-	b.handle("/votegif", handlers.HandleVoteGifs)
-	b.handle("/gif", handlers.HandleVoteGifs)
+	b.bot.Handle(tb.OnPoll, b.tracked(b.handlePollUpdate))
+	b.bot.Handle(tb.OnPollAnswer, b.tracked(b.handlePollAnswer))
 
-	b.handle("/votemedia", handlers.HandleVoteMedia)
-	b.handle("/media", handlers.HandleVoteMedia)
+	b.bot.Handle(tb.OnChatMember, b.tracked(b.handleChatMember))
+	b.bot.Handle(tb.OnMyChatMember, b.tracked(b.handleChatMember))
+}
 
-	b.handle("/help", handlers.HandleHelp)
+// counts the handler in for Start to wait on, updates that come in
+// after shutdown began are dropped
+func (b *Bot) tracked(handler tb.HandlerFunc) tb.HandlerFunc {
+	return func(tbCtx tb.Context) error {
+		b.handlersMutex.Lock()
+		if b.handlersStopped {
+			b.handlersMutex.Unlock()
+			return nil
+		}
+		b.handlers.Add(1)
+		b.handlersMutex.Unlock()
+		defer b.handlers.Done()
 
-	b.bot.Handle(tb.OnText, b.handleAllMessages)
-	b.bot.Handle(tb.OnPhoto, b.handleAllMessages)
-	b.bot.Handle(tb.OnVideo, b.handleAllMessages)
-	b.bot.Handle(tb.OnDocument, b.handleAllMessages)
-	b.bot.Handle(tb.OnAudio, b.handleAllMessages)
-	b.bot.Handle(tb.OnVoice, b.handleAllMessages)
-	b.bot.Handle(tb.OnSticker, b.handleAllMessages)
+		return handler(tbCtx)
+	}
 }
 
 func (b *Bot) handleAllMessages(tbCtx tb.Context) error {
-	return b.messageFilter.HandleMessage(tbCtx.Message())
+	msg := tbCtx.Message()
+
+	// voter eligibility is based on what the bot has seen in the chat
+	if msg.Sender != nil && msg.FromGroup() {
+		if err := b.memberStorage.RecordMessage(msg.Chat.ID, msg.Sender, time.Now()); err != nil {
+			b.logger.Error("failed to record member activity", slog.String("error", err.Error()))
+		}
+	}
+
+	return b.messageFilter.HandleMessage(msg)
+}
+
+func (b *Bot) handlePollUpdate(tbCtx tb.Context) error {
+	b.pollMonitor.HandlePollUpdate(tbCtx.Poll())
+	return nil
 }
 
-func (b *Bot) Start() {
-	b.bot.Start()
+func (b *Bot) handlePollAnswer(tbCtx tb.Context) error {
+	b.pollMonitor.HandlePollAnswer(tbCtx.PollAnswer())
+	return nil
+}
+
+func (b *Bot) handleChatMember(tbCtx tb.Context) error {
+	b.adminCache.HandleChatMemberUpdate(tbCtx.ChatMember())
+	return nil
+}
+
+// runs the bot until ctx is cancelled, then stops receiving updates
+// and waits for the handlers and polls that are being processed. polls still waiting
+// stay in storage and are restored on the next start
+func (b *Bot) Start(ctx context.Context) {
+	b.scheduler.Start(ctx)
+	// before the poller, so answers queued while the bot was down find their polls
+	b.pollMonitor.RestoreActivePolls()
+	go b.bot.Start()
+
+	<-ctx.Done()
+	b.logger.Info("shutting down")
+
+	b.bot.Stop()
+
+	b.handlersMutex.Lock()
+	b.handlersStopped = true
+	b.handlersMutex.Unlock()
+	b.handlers.Wait()
+
+	b.scheduler.Wait()
+
+	b.logger.Info("bot stopped")
 }
 
 type botContext struct {
@@ -78,6 +216,7 @@ type botContext struct {
 	bot         *Bot
 	logger      *slog.Logger
 	pollStorage domain.PollStorage
+	pollHistory domain.PollHistory
 }
 
 func (ctx *botContext) BotUser() *tb.User {
@@ -85,7 +224,63 @@ func (ctx *botContext) BotUser() *tb.User {
 }
 
 func (ctx *botContext) BotAPI() tb.API {
-	return ctx.bot.bot
+	return ctx.bot.api
+}
+
+// the replies of tb.Context go to the bot directly, these count against the rate limits
+
+func (ctx *botContext) Send(what any, opts ...any) error {
+	if threadID := ctx.ThreadID(); threadID != 0 {
+		opts = append(opts, &tb.Topic{ThreadID: threadID})
+	}
+	_, err := ctx.bot.api.Send(ctx.Recipient(), what, opts...)
+	return err
+}
+
+func (ctx *botContext) Reply(what any, opts ...any) error {
+	msg := ctx.Message()
+	if msg == nil {
+		return tb.ErrBadContext
+	}
+	_, err := ctx.bot.api.Reply(msg, what, opts...)
+	return err
+}
+
+func (ctx *botContext) Edit(what any, opts ...any) error {
+	callback := ctx.Callback()
+	if callback == nil {
+		return tb.ErrBadContext
+	}
+	_, err := ctx.bot.api.Edit(callback, what, opts...)
+	return err
+}
+
+func (ctx *botContext) Respond(resp ...*tb.CallbackResponse) error {
+	callback := ctx.Callback()
+	if callback == nil {
+		return tb.ErrBadContext
+	}
+	return ctx.bot.api.Respond(callback, resp...)
+}
+
+func (ctx *botContext) AdminCache() domain.AdminCache {
+	return ctx.bot.adminCache
+}
+
+func (ctx *botContext) PollKinds() *domain.PollKinds {
+	return ctx.bot.pollKinds
+}
+
+func (ctx *botContext) MemberStorage() domain.MemberStorage {
+	return ctx.bot.memberStorage
+}
+
+func (ctx *botContext) Config() domain.ConfigProvider {
+	return ctx.bot.config
+}
+
+func (ctx *botContext) PollGuard() domain.PollGuard {
+	return ctx.bot.pollGuard
 }
 
 func (ctx *botContext) Log() *slog.Logger {
@@ -94,10 +289,11 @@ func (ctx *botContext) Log() *slog.Logger {
 
 func (ctx *botContext) WithLogger(logger *slog.Logger) domain.Context {
 	return &botContext{
-		Context: ctx.Context,
-		bot:     ctx.bot,
-		logger:  logger,
+		Context:     ctx.Context,
+		bot:         ctx.bot,
+		logger:      logger,
 		pollStorage: ctx.pollStorage,
+		pollHistory: ctx.pollHistory,
 	}
 }
 
@@ -105,11 +301,20 @@ func (ctx *botContext) PollStorage() domain.PollStorage {
 	return ctx.pollStorage
 }
 
+func (ctx *botContext) PollHistory() domain.PollHistory {
+	return ctx.pollHistory
+}
+
 func (ctx *botContext) StartPollMonitoring(poll *domain.ActivePoll) {
 	ctx.bot.pollMonitor.StartPollMonitoring(poll)
 }
 
-func (b *Bot) handle(endpoint any, handler func(domain.Context) error) {
+func (ctx *botContext) DropPoll(poll *domain.ActivePoll) error {
+	return ctx.bot.pollMonitor.DropPoll(poll)
+}
+
+// registers the handler behind the access check of the chat
+func (b *Bot) handle(endpoint any, access domain.CommandAccess, handler func(domain.Context) error) {
 	wrappedHandler := func(tbCtx tb.Context) error {
 		logger := b.logger
 		if chat := tbCtx.Chat(); chat != nil {
@@ -121,10 +326,30 @@ func (b *Bot) handle(endpoint any, handler func(domain.Context) error) {
 			bot:         b,
 			logger:      logger,
 			pollStorage: b.pollStorage,
+			pollHistory: b.pollHistory,
+		}
+
+		if chat, sender := tbCtx.Chat(), tbCtx.Sender(); chat != nil && sender != nil {
+			allowed, err := b.access.Allowed(chat, sender, access)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				logger.Debug("command denied", slog.Int64("user_id", sender.ID))
+				return denyAccess(ctx)
+			}
 		}
 
 		return handler(ctx)
 	}
 
-	b.bot.Handle(endpoint, wrappedHandler)
+	b.bot.Handle(endpoint, b.tracked(wrappedHandler))
+}
+
+func denyAccess(ctx domain.Context) error {
+	const text = "не могу"
+	if ctx.Callback() != nil {
+		return ctx.Respond(&tb.CallbackResponse{Text: text})
+	}
+	return ctx.Reply(text)
 }
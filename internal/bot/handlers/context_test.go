@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/internal/services"
+	"github.com/uaru-shit/votes/pkg/utils"
+	tb "gopkg.in/telebot.v4"
+)
+
+const testChatID = -100
+
+// handler context backed by real config and poll kinds, anything else
+// a test doesn't set panics on the nil embedded interfaces
+type fakeContext struct {
+	domain.Context
+	api     *fakeAPI
+	message *tb.Message
+	args    []string
+	config  domain.ConfigProvider
+	kinds   *domain.PollKinds
+	polls   domain.PollStorage
+	members domain.MemberStorage
+}
+
+func newFakeContext(t *testing.T) *fakeContext {
+	t.Helper()
+
+	logger := slog.New(slog.DiscardHandler)
+	dir := t.TempDir()
+	storage, err := utils.NewFileSettingsStorage(filepath.Join(dir, "settings.json"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	polls, err := utils.NewFilePollStorage(filepath.Join(dir, "active_polls.json"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err := utils.NewFileMemberStorage(filepath.Join(dir, "members.json"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { members.Close() })
+
+	return &fakeContext{
+		api:     &fakeAPI{},
+		message: &tb.Message{Chat: &tb.Chat{ID: testChatID, Type: tb.ChatSuperGroup}, Sender: &tb.User{ID: 1}},
+		config:  services.NewConfigService(storage, logger),
+		kinds:   services.NewPollKinds(nil, logger, nil),
+		polls:   polls,
+		members: members,
+	}
+}
+
+func (c *fakeContext) Message() *tb.Message { return c.message }
+func (c *fakeContext) Chat() *tb.Chat       { return c.message.Chat }
+func (c *fakeContext) Sender() *tb.User     { return c.message.Sender }
+func (c *fakeContext) Args() []string       { return c.args }
+func (c *fakeContext) Log() *slog.Logger    { return slog.New(slog.DiscardHandler) }
+func (c *fakeContext) BotAPI() tb.API       { return c.api }
+
+func (c *fakeContext) Config() domain.ConfigProvider { return c.config }
+func (c *fakeContext) PollKinds() *domain.PollKinds  { return c.kinds }
+
+func (c *fakeContext) PollStorage() domain.PollStorage     { return c.polls }
+func (c *fakeContext) MemberStorage() domain.MemberStorage { return c.members }
+
+func (c *fakeContext) Reply(what any, opts ...any) error {
+	_, err := c.api.Reply(c.message, what, opts...)
+	return err
+}
+
+func (c *fakeContext) Edit(what any, opts ...any) error {
+	c.api.sent = append(c.api.sent, what.(string))
+	return nil
+}
+
+func (c *fakeContext) Respond(resp ...*tb.CallbackResponse) error { return nil }
+
+// records the texts sent, the rest of the api is left nil
+type fakeAPI struct {
+	tb.API
+	sent []string
+}
+
+func (a *fakeAPI) Reply(to *tb.Message, what any, opts ...any) (*tb.Message, error) {
+	a.sent = append(a.sent, what.(string))
+	return &tb.Message{}, nil
+}
+
+func (a *fakeAPI) last() string {
+	if len(a.sent) == 0 {
+		return ""
+	}
+	return a.sent[len(a.sent)-1]
+}
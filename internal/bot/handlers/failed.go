@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
+	tb "gopkg.in/telebot.v4"
+)
+
+// uniques of the dead letter buttons, routed to HandleFailedRetry and HandleFailedDrop
+const (
+	FailedRetryButtonUnique = "failed_retry"
+	FailedDropButtonUnique  = "failed_drop"
+)
+
+// lists polls of the chat whose processing failed too many times
+func HandleFailed(ctx domain.Context) error {
+	polls, err := deadLetteredPolls(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(polls) == 0 {
+		return ctx.Reply("Зависших голосований нет")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<b>Голосования, которые не получилось завершить</b>\n")
+
+	markup := &tb.ReplyMarkup{}
+	var rows []tb.Row
+	for i, poll := range polls {
+		fmt.Fprintf(&sb, "\n%d. <b>%s</b> против %s, попыток: %d\n<code>%s</code>\n",
+			i+1,
+			pollTypeName(ctx, poll.Type),
+			pollTargetName(poll),
+			poll.Attempts,
+			html.EscapeString(poll.LastError))
+
+		rows = append(rows, markup.Row(
+			markup.Data(fmt.Sprintf("%d: повторить", i+1), FailedRetryButtonUnique, poll.ID),
+			markup.Data(fmt.Sprintf("%d: убрать", i+1), FailedDropButtonUnique, poll.ID),
+		))
+	}
+	markup.Inline(rows...)
+
+	_, err = ctx.BotAPI().Reply(ctx.Message(), sb.String(), &tb.SendOptions{
+		ParseMode:   tb.ModeHTML,
+		ReplyMarkup: markup,
+	})
+	return err
+}
+
+// gives a dead-lettered poll a fresh set of attempts
+func HandleFailedRetry(ctx domain.Context) error {
+	poll, err := findDeadLetteredPoll(ctx, ctx.Data())
+	if err != nil {
+		return err
+	}
+	if poll == nil {
+		return ctx.Respond(&tb.CallbackResponse{Text: "Уже нет такого"})
+	}
+
+	poll.Attempts = 0
+	poll.DeadLetter = false
+	poll.LastError = ""
+
+	if err := ctx.PollStorage().SavePoll(poll); err != nil {
+		return fmt.Errorf("failed to save poll: %w", err)
+	}
+
+	ctx.Log().Info("dead-lettered poll retried",
+		slog.String("poll_id", poll.ID),
+		slog.Int64("admin_id", ctx.Sender().ID))
+
+	ctx.StartPollMonitoring(poll)
+	return ctx.Respond(&tb.CallbackResponse{Text: "Пробую ещё раз"})
+}
+
+// removes a dead-lettered poll, only now it goes to the history with its error
+func HandleFailedDrop(ctx domain.Context) error {
+	poll, err := findDeadLetteredPoll(ctx, ctx.Data())
+	if err != nil {
+		return err
+	}
+	if poll == nil {
+		return ctx.Respond(&tb.CallbackResponse{Text: "Уже нет такого"})
+	}
+
+	if err := ctx.DropPoll(poll); err != nil {
+		return err
+	}
+
+	ctx.Log().Info("dead-lettered poll dropped",
+		slog.String("poll_id", poll.ID),
+		slog.Int64("admin_id", ctx.Sender().ID))
+
+	return ctx.Respond(&tb.CallbackResponse{Text: "Убрал"})
+}
+
+func deadLetteredPolls(ctx domain.Context) ([]*domain.ActivePoll, error) {
+	polls, err := ctx.PollStorage().GetPollsByChat(ctx.Chat().ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load polls: %w", err)
+	}
+
+	var failed []*domain.ActivePoll
+	for _, poll := range polls {
+		if poll.DeadLetter {
+			failed = append(failed, poll)
+		}
+	}
+
+	return failed, nil
+}
+
+func findDeadLetteredPoll(ctx domain.Context, pollID string) (*domain.ActivePoll, error) {
+	polls, err := deadLetteredPolls(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, poll := range polls {
+		if poll.ID == pollID {
+			return poll, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func pollTypeName(ctx domain.Context, pollType domain.PollType) string {
+	if kind := ctx.PollKinds().Get(pollType); kind != nil {
+		return kind.Name()
+	}
+	return string(pollType)
+}
+
+func pollTargetName(poll *domain.ActivePoll) string {
+	member, err := utils.DeserializeMember(poll.MemberData)
+	if err == nil && member.User != nil && member.User.Username != "" {
+		return "@" + html.EscapeString(member.User.Username)
+	}
+	return fmt.Sprintf("<code>%d</code>", poll.UserID)
+}
@@ -3,10 +3,10 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/uaru-shit/votes/internal/domain"
@@ -20,16 +20,24 @@ func generatePollID() string {
 	return hex.EncodeToString(bytes)
 }
 
-func createPoll(ctx domain.Context, pollType domain.PollType, user *tb.User, member *tb.ChatMember, question string, options []string) error {
+func createPoll(ctx domain.Context, pollType domain.PollType, user *tb.User, member *tb.ChatMember, question string, options []string, actionDuration time.Duration) error {
 	bot := ctx.BotAPI()
 	pollID := generatePollID()
-	
+
+	if actionDuration > 0 {
+		question = fmt.Sprintf("%s (на %s)", question, utils.FormatDuration(actionDuration))
+	}
+	// without a replied message nothing else tells who the poll is about
+	if repliedMessage(ctx.Message()) == nil {
+		question = fmt.Sprintf("%s: %s", targetName(user), question)
+	}
+
 	// Convert string options to PollOption structs
 	pollOptions := make([]tb.PollOption, len(options))
 	for i, option := range options {
 		pollOptions[i] = tb.PollOption{Text: option}
 	}
-	
+
 	msg, err := bot.Reply(ctx.Message(), &tb.Poll{
 		Question:  question,
 		Anonymous: false,
@@ -44,7 +52,7 @@ func createPoll(ctx domain.Context, pollType domain.PollType, user *tb.User, mem
 		return fmt.Errorf("failed to serialize member: %w", err)
 	}
 
-	pollDuration := getPollDuration(ctx)
+	pollDuration := ctx.Config().Settings(ctx.Chat().ID).PollDuration
 
 	const (
 		minDuration = 30 * time.Second
@@ -52,25 +60,29 @@ func createPoll(ctx domain.Context, pollType domain.PollType, user *tb.User, mem
 	)
 
 	if pollDuration < minDuration {
-		ctx.Log().Warn("poll duration too short, using minimum", 
+		ctx.Log().Warn("poll duration too short, using minimum",
 			slog.String("provided", pollDuration.String()),
 			slog.String("minimum", minDuration.String()))
 		pollDuration = minDuration
 	} else if pollDuration > maxDuration {
-		ctx.Log().Warn("poll duration too long, using maximum", 
+		ctx.Log().Warn("poll duration too long, using maximum",
 			slog.String("provided", pollDuration.String()),
 			slog.String("maximum", maxDuration.String()))
 		pollDuration = maxDuration
 	}
 	activePoll := &domain.ActivePoll{
-		ID:         pollID,
-		Type:       pollType,
-		ChatID:     ctx.Chat().ID,
-		MessageID:  msg.ID,
-		UserID:     user.ID,
-		CreatedAt:  time.Now(),
-		ExpiresAt:  time.Now().Add(pollDuration),
-		MemberData: memberData,
+		ID:             pollID,
+		Type:           pollType,
+		ChatID:         ctx.Chat().ID,
+		MessageID:      msg.ID,
+		TelegramPollID: msg.Poll.ID,
+		UserID:         user.ID,
+		InitiatorID:    ctx.Sender().ID,
+		CreatedAt:      time.Now(),
+		ExpiresAt:      time.Now().Add(pollDuration),
+		MemberData:     memberData,
+		State:          domain.PollStatePending,
+		ActionDuration: actionDuration,
 	}
 
 	if err := ctx.PollStorage().SavePoll(activePoll); err != nil {
@@ -81,34 +93,30 @@ func createPoll(ctx domain.Context, pollType domain.PollType, user *tb.User, mem
 	return nil
 }
 
-func getPollDuration(ctx domain.Context) time.Duration {
-	const defaultDuration = 30 * time.Minute
+const (
+	minActionDuration = time.Minute
+	// telegram treats restrictions longer than 366 days as permanent
+	maxActionDuration = 366 * 24 * time.Hour
+)
+
+// optional duration argument, like /ban 1d. without it the action is permanent
+func getActionDuration(args []string) (time.Duration, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
 
-	durationStr := os.Getenv("VOTEBAN_POLL_DURATION_SECONDS")
-	if durationStr == "" {
-		ctx.Log().Debug("using default poll duration", 
-			slog.String("duration", defaultDuration.String()))
-		return defaultDuration
+	duration, err := utils.ParseDuration(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("не понял срок %s, пиши как 30m, 3h, 1d или 1w", args[0])
 	}
 
-	duration, err := strconv.Atoi(durationStr)
-	if err != nil || duration <= 0 {
-		ctx.Log().Warn("invalid poll duration in environment", 
-			slog.String("value", durationStr),
-			slog.String("error", err.Error()))
-		return defaultDuration
+	if duration < minActionDuration || duration > maxActionDuration {
+		return 0, fmt.Errorf("срок должен быть от минуты до года")
 	}
 
-	pollDuration := time.Duration(duration) * time.Second
-	ctx.Log().Info("using custom poll duration", 
-		slog.Int("seconds", duration),
-		slog.String("duration", pollDuration.String()))
-	
-	return pollDuration
+	return duration, nil
 }
 
-
-
 func validatePollRequest(ctx domain.Context, user *tb.User) (*tb.ChatMember, error) {
 	bot := ctx.BotAPI()
 
@@ -121,7 +129,7 @@ func validatePollRequest(ctx domain.Context, user *tb.User) (*tb.ChatMember, err
 		return nil, fmt.Errorf("failed to get member: %w", err)
 	}
 
-	admins, err := bot.AdminsOf(ctx.Chat())
+	admins, err := ctx.AdminCache().AdminsOf(ctx.Chat())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get admins: %w", err)
 	}
@@ -137,101 +145,55 @@ func validatePollRequest(ctx domain.Context, user *tb.User) (*tb.ChatMember, err
 	return member, nil
 }
 
-func validateAdminAccess(ctx domain.Context) error {
-	bot := ctx.BotAPI()
-	admins, err := bot.AdminsOf(ctx.Chat())
-	if err != nil {
-		return fmt.Errorf("failed to get admins: %w", err)
-	}
-
-	if !utils.IsAdmin(ctx.Message().Sender.ID, admins) {
-		return fmt.Errorf(" не могу")
-	}
-	return nil
-}
-
-func HandleVoteban(ctx domain.Context) error {
-	if err := validateAdminAccess(ctx); err != nil {
-		return ctx.Reply(err.Error())
-	}
-
-	if ctx.Message().ReplyTo == nil {
-		return ctx.Reply("ответь на сообщение")
-	}
-	userToBan := ctx.Message().ReplyTo.Sender
-
-	member, err := validatePollRequest(ctx, userToBan)
-	if err != nil {
-		return ctx.Reply(err.Error())
-	}
-
-	return createPoll(ctx, domain.PollTypeBan, userToBan, member, "Банить?", []string{"Да", "Нет"})
-}
-
-func HandleVoteUnban(ctx domain.Context) error {
-	if err := validateAdminAccess(ctx); err != nil {
-		return ctx.Reply(err.Error())
-	}
-
-	if ctx.Message().ReplyTo == nil {
-		return ctx.Reply("ответь на сообщение")
-	}
-	userToUnban := ctx.Message().ReplyTo.Sender
-
-	member, err := validatePollRequest(ctx, userToUnban)
-	if err != nil {
-		return ctx.Reply(err.Error())
-	}
-
-	return createPoll(ctx, domain.PollTypeUnban, userToUnban, member, "Разбанить?", []string{"Да", "Нет"})
-}
-
-func HandleVoteGifs(ctx domain.Context) error {
-	if err := validateAdminAccess(ctx); err != nil {
-		return ctx.Reply(err.Error())
-	}
+// starts a poll of the given kind against the user picked by resolveTarget
+func HandleVote(kind domain.PollKind) func(domain.Context) error {
+	return func(ctx domain.Context) error {
+		if settings := ctx.Config().Settings(ctx.Chat().ID); !settings.PollTypeEnabled(kind.Type()) {
+			return ctx.Reply("такое голосование в этом чате выключено")
+		}
 
-	if ctx.Message().ReplyTo == nil {
-		return ctx.Reply("ответь на сообщение")
-	}
-	user := ctx.Message().ReplyTo.Sender
+		user, args, err := resolveTarget(ctx)
+		if err != nil {
+			return ctx.Reply(err.Error())
+		}
 
-	member, err := validatePollRequest(ctx, user)
-	if err != nil {
-		return ctx.Reply(err.Error())
-	}
+		var actionDuration time.Duration
+		if kind.TakesDuration() {
+			if actionDuration, err = getActionDuration(args); err != nil {
+				return ctx.Reply(err.Error())
+			}
+		}
 
-	return createPoll(ctx, domain.PollTypeGifs, user, member, "Стикеры/гифки этому челу:", []string{"Запретить", "Разрешить"})
-}
+		member, err := validatePollRequest(ctx, user)
+		if err != nil {
+			return ctx.Reply(err.Error())
+		}
 
-func HandleVoteMedia(ctx domain.Context) error {
-	if err := validateAdminAccess(ctx); err != nil {
-		return ctx.Reply(err.Error())
-	}
+		if err := kind.Validate(member); err != nil {
+			return ctx.Reply(err.Error())
+		}
 
-	if ctx.Message().ReplyTo == nil {
-		return ctx.Reply("ответь на сообщение")
-	}
-	user := ctx.Message().ReplyTo.Sender
+		release, err := ctx.PollGuard().Acquire(ctx.Chat().ID, member.User.ID, ctx.Sender().ID, kind.Type())
+		var limitErr *domain.PollLimitError
+		if errors.As(err, &limitErr) {
+			return ctx.Reply(limitErr.Error())
+		} else if err != nil {
+			return err
+		}
 
-	member, err := validatePollRequest(ctx, user)
-	if err != nil {
-		return ctx.Reply(err.Error())
+		// a user given by id or username is only partially known until now
+		err = createPoll(ctx, kind.Type(), member.User, member, kind.Question(), kind.Options(), actionDuration)
+		release(err == nil)
+		return err
 	}
-
-	return createPoll(ctx, domain.PollTypeMedia, user, member, "Медиа этому челу:", []string{"Запретить", "Разрешить"})
 }
 
 func HandleInstaban(ctx domain.Context) error {
-	if err := validateAdminAccess(ctx); err != nil {
+	userToBan, _, err := resolveTarget(ctx)
+	if err != nil {
 		return ctx.Reply(err.Error())
 	}
 
-	if ctx.Message().ReplyTo == nil {
-		return ctx.Reply("ответь на сообщение")
-	}
-	userToBan := ctx.Message().ReplyTo.Sender
-
 	if !ctx.Message().FromGroup() {
 		return ctx.Reply("команда работает только в группах")
 	}
@@ -241,8 +203,9 @@ func HandleInstaban(ctx domain.Context) error {
 	if err != nil {
 		return ctx.Reply("не могу получить данные юзера")
 	}
+	userToBan = member.User
 
-	admins, err := bot.AdminsOf(ctx.Chat())
+	admins, err := ctx.AdminCache().AdminsOf(ctx.Chat())
 	if err != nil {
 		return ctx.Reply("не могу получить список админов")
 	}
@@ -252,7 +215,7 @@ func HandleInstaban(ctx domain.Context) error {
 	}
 
 	if err := bot.Ban(ctx.Chat(), member); err != nil {
-		ctx.Log().Error("failed to ban user", 
+		ctx.Log().Error("failed to ban user",
 			slog.Int64("user_id", userToBan.ID),
 			slog.String("error", err.Error()))
 		_, replyErr := bot.Send(ctx.Chat(), "не забанился")
@@ -262,35 +225,100 @@ func HandleInstaban(ctx domain.Context) error {
 		return err
 	}
 
-	ctx.Log().Info("user banned instantly", 
+	ctx.Log().Info("user banned instantly",
 		slog.Int64("user_id", userToBan.ID),
 		slog.String("username", userToBan.Username),
 		slog.Int64("admin_id", ctx.Message().Sender.ID))
 
-	_, err = bot.Send(ctx.Chat(), fmt.Sprintf("@%s BANNNED BY 1984 FORCES", userToBan.Username))
+	_, err = bot.Send(ctx.Chat(), fmt.Sprintf("%s BANNNED BY 1984 FORCES", targetName(userToBan)))
 	if err != nil {
 		ctx.Log().Error("failed to send success msg", slog.String("error", err.Error()))
 	}
 	return err
 }
 
+// fetches the admin list again, for changes the bot didn't get an update about
+func HandleRefreshAdmins(ctx domain.Context) error {
+	if !ctx.Message().FromGroup() {
+		return ctx.Reply("команда работает только в группах")
+	}
+
+	admins, err := ctx.AdminCache().AdminsOf(ctx.Chat())
+	if err != nil {
+		return fmt.Errorf("failed to get admins: %w", err)
+	}
+
+	// a member missing from the cached list may just have been promoted,
+	// but anyone can ask that, so not more often than once a minute
+	minAge := time.Duration(0)
+	if !utils.IsAdmin(ctx.Sender().ID, admins) {
+		minAge = time.Minute
+	}
+
+	admins, err = ctx.AdminCache().Refresh(ctx.Chat(), minAge)
+	if err != nil {
+		return fmt.Errorf("failed to get admins: %w", err)
+	}
+
+	if !utils.IsAdmin(ctx.Sender().ID, admins) {
+		return ctx.Reply(" не могу")
+	}
+
+	return ctx.Reply(fmt.Sprintf("Список админов обновлён, их %d", len(admins)))
+}
+
 func HandleHelp(ctx domain.Context) error {
-	helpText := `<b>COMMANDS</b>
+	settings := ctx.Config().Settings(ctx.Chat().ID)
+	english := settings.Language == domain.LanguageEnglish
 
-<b>Ban/Unban:</b>
-/ban - Start vote to ban user
-/unban - Start vote to unban user
+	var sb strings.Builder
+	if english {
+		sb.WriteString("<b>COMMANDS</b>\n\n<b>Votes:</b>\n")
+	} else {
+		sb.WriteString("<b>КОМАНДЫ</b>\n\n<b>Голосования:</b>\n")
+	}
+
+	// disabled kinds are left out, their commands only answer that they are off
+	for _, kind := range ctx.PollKinds().All() {
+		if !settings.PollTypeEnabled(kind.Type()) {
+			continue
+		}
 
-<b>Permissions:</b>
-/gif - Start vote to restrict gifs/stickers
-/media - Start vote to restrict media
+		command := "/" + kind.Commands()[0]
+		if kind.TakesDuration() {
+			command += " [1d]"
+		}
+		if english {
+			fmt.Fprintf(&sb, "%s - %s\n", command, kind.Help())
+		} else {
+			fmt.Fprintf(&sb, "%s - голосование: %s\n", command, kind.Name())
+		}
+	}
 
-<b>Usage:</b> Reply to any message with a command to start voting.`
+	if english {
+		sb.WriteString(`
+<b>Moderation:</b>
+/polls - List votes running in this chat
+/history - Show past votes against user
+/failed - Show votes that could not be completed
+/refreshadmins - Reload the admin list after changing admins
+/settings - Change poll duration, quorum, votes and language of this chat
 
-	_, err := ctx.BotAPI().Reply(ctx.Message(), helpText, &tb.SendOptions{
+<b>Usage:</b> Reply to any message with a command, or put @username, id:123456 or a mention after it: /ban @username 1d. Time is given as 30m, 3h, 1d or 1w.`)
+	} else {
+		sb.WriteString(`
+<b>Модерация:</b>
+/polls - голосования, которые идут в чате
+/history - прошлые голосования против юзера
+/failed - голосования, которые не получилось завершить
+/refreshadmins - перечитать список админов после изменений
+/settings - длительность, кворум, голосования и язык этого чата
+
+<b>Как пользоваться:</b> ответь командой на сообщение или укажи после неё @username, id:123456 или упоминание: /ban @username 1d. Срок пишется как 30m, 3h, 1d или 1w.`)
+	}
+
+	_, err := ctx.BotAPI().Reply(ctx.Message(), sb.String(), &tb.SendOptions{
 		ParseMode: tb.ModeHTML,
 	})
 	return err
 }
-
-
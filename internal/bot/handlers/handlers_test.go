@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHelpFollowsChatLanguage(t *testing.T) {
+	ctx := newFakeContext(t)
+
+	if err := HandleHelp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if help := ctx.api.last(); !strings.Contains(help, "<b>COMMANDS</b>") {
+		t.Fatalf("default help is not in english:\n%s", help)
+	}
+
+	ctx.args = []string{"language"}
+	if err := HandleSettingsButton(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if menu := ctx.api.last(); !strings.Contains(menu, "Настройки чата") {
+		t.Errorf("settings menu is not in russian after switching:\n%s", menu)
+	}
+
+	if err := HandleHelp(ctx); err != nil {
+		t.Fatal(err)
+	}
+	help := ctx.api.last()
+	if !strings.Contains(help, "<b>КОМАНДЫ</b>") || strings.Contains(help, "Moderation") {
+		t.Errorf("help is not in russian after switching:\n%s", help)
+	}
+	if !strings.Contains(help, "/ban [1d] - голосование: бан") {
+		t.Errorf("poll kinds are not listed in russian:\n%s", help)
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
+	tb "gopkg.in/telebot.v4"
+)
+
+const historyPageSize = 5
+
+// unique of the pagination buttons, routed to HandleHistoryPage
+const HistoryButtonUnique = "history"
+
+var decisionNames = map[domain.PollDecision]string{
+	domain.PollDecisionPassed:   "принято",
+	domain.PollDecisionRejected: "отклонено",
+	domain.PollDecisionTied:     "ничья",
+	domain.PollDecisionNoQuorum: "нет кворума",
+}
+
+func HandleHistory(ctx domain.Context) error {
+	user, _, err := resolveTarget(ctx)
+	if err != nil {
+		return ctx.Reply(err.Error())
+	}
+
+	text, markup, err := renderHistoryPage(ctx, user.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = ctx.BotAPI().Reply(ctx.Message(), text, &tb.SendOptions{
+		ParseMode:   tb.ModeHTML,
+		ReplyMarkup: markup,
+	})
+	return err
+}
+
+func HandleHistoryPage(ctx domain.Context) error {
+	args := ctx.Args()
+	if len(args) != 2 {
+		return ctx.Respond()
+	}
+
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return ctx.Respond()
+	}
+
+	page, err := strconv.Atoi(args[1])
+	if err != nil || page < 0 {
+		return ctx.Respond()
+	}
+
+	text, markup, err := renderHistoryPage(ctx, userID, page)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Edit(text, &tb.SendOptions{
+		ParseMode:   tb.ModeHTML,
+		ReplyMarkup: markup,
+	}); err != nil {
+		return err
+	}
+
+	return ctx.Respond()
+}
+
+func renderHistoryPage(ctx domain.Context, userID int64, page int) (string, *tb.ReplyMarkup, error) {
+	// one extra record tells whether there is a next page
+	records, err := ctx.PollHistory().GetRecordsByUser(ctx.Chat().ID, userID, historyPageSize+1, page*historyPageSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load poll history: %w", err)
+	}
+
+	hasNext := len(records) > historyPageSize
+	if hasNext {
+		records = records[:historyPageSize]
+	}
+
+	if len(records) == 0 {
+		if page == 0 {
+			return "Голосований против этого юзера не было", nil, nil
+		}
+		return "Больше ничего нет", historyMarkup(userID, page, false), nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<b>История голосований против %s</b>\n", historyUserName(records[0]))
+
+	for _, record := range records {
+		sb.WriteString("\n")
+		sb.WriteString(formatHistoryRecord(ctx, record))
+	}
+
+	return sb.String(), historyMarkup(userID, page, hasNext), nil
+}
+
+func historyUserName(record *domain.PollRecord) string {
+	if record.Username != "" {
+		return "@" + html.EscapeString(record.Username)
+	}
+	return fmt.Sprintf("<code>%d</code>", record.UserID)
+}
+
+func formatHistoryRecord(ctx domain.Context, record *domain.PollRecord) string {
+	typeName := pollTypeName(ctx, record.Type)
+
+	decision, ok := decisionNames[record.Decision]
+	if !ok {
+		decision = string(record.Decision)
+	}
+	if record.Decision == "" {
+		decision = "не решено"
+	}
+
+	tally := make([]string, len(record.Options))
+	for i, option := range record.Options {
+		tally[i] = fmt.Sprintf("%s %d", html.EscapeString(option.Text), option.Votes)
+	}
+
+	line := fmt.Sprintf("%s — <b>%s</b>: %s → %s",
+		record.FinishedAt.Format("02.01.2006 15:04"),
+		typeName,
+		strings.Join(tally, " / "),
+		decision)
+
+	if record.ActionDuration > 0 {
+		line += fmt.Sprintf(" на %s", utils.FormatDuration(record.ActionDuration))
+	}
+	if record.ClosedEarly {
+		line += " (досрочно)"
+	}
+	if record.Error != "" {
+		line += " (не получилось)"
+	}
+
+	return line
+}
+
+func historyMarkup(userID int64, page int, hasNext bool) *tb.ReplyMarkup {
+	markup := &tb.ReplyMarkup{}
+	user := strconv.FormatInt(userID, 10)
+
+	var buttons []tb.Btn
+	if page > 0 {
+		buttons = append(buttons, markup.Data("« назад", HistoryButtonUnique, user, strconv.Itoa(page-1)))
+	}
+	if hasNext {
+		buttons = append(buttons, markup.Data("дальше »", HistoryButtonUnique, user, strconv.Itoa(page+1)))
+	}
+
+	if len(buttons) == 0 {
+		return nil
+	}
+
+	markup.Inline(markup.Row(buttons...))
+	return markup
+}
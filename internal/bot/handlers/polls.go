@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
+	tb "gopkg.in/telebot.v4"
+)
+
+// lists the polls of the chat still being voted on or decided. polls waiting
+// to lift their action are over, dead-lettered ones are in /failed
+func HandlePolls(ctx domain.Context) error {
+	if !ctx.Message().FromGroup() {
+		return ctx.Reply("команда работает только в группах")
+	}
+
+	polls, err := ctx.PollStorage().GetPollsByChat(ctx.Chat().ID)
+	if err != nil {
+		return fmt.Errorf("failed to load polls: %w", err)
+	}
+
+	polls = slices.DeleteFunc(polls, func(poll *domain.ActivePoll) bool {
+		return poll.DeadLetter || poll.CurrentState() == domain.PollStateLifting
+	})
+
+	if len(polls) == 0 {
+		return ctx.Reply("Сейчас голосований нет")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<b>Голосования в чате</b>\n")
+
+	for i, poll := range polls {
+		fmt.Fprintf(&sb, "\n%d. <b>%s</b> против %s, начал %s\n%s",
+			i+1,
+			pollTypeName(ctx, poll.Type),
+			pollTargetName(poll),
+			initiatorName(ctx, poll.InitiatorID),
+			pollStatus(poll))
+
+		if link := messageLink(ctx.Chat(), poll.MessageID); link != "" {
+			fmt.Fprintf(&sb, " — <a href=\"%s\">к голосованию</a>", link)
+		}
+		sb.WriteString("\n")
+	}
+
+	_, err = ctx.BotAPI().Reply(ctx.Message(), sb.String(), &tb.SendOptions{
+		ParseMode:             tb.ModeHTML,
+		DisableWebPagePreview: true,
+	})
+	return err
+}
+
+// only the id is stored with the poll, the username comes from what the bot has seen
+func initiatorName(ctx domain.Context, initiatorID int64) string {
+	member, err := ctx.MemberStorage().GetMember(ctx.Chat().ID, initiatorID)
+	if err == nil && member != nil && member.Username != "" {
+		return "@" + html.EscapeString(member.Username)
+	}
+	return fmt.Sprintf("<code>%d</code>", initiatorID)
+}
+
+func pollStatus(poll *domain.ActivePoll) string {
+	switch {
+	case poll.CurrentState() != domain.PollStatePending || poll.ClosedEarly:
+		return "подводятся итоги"
+	default:
+		return "осталось " + utils.FormatWait(time.Until(poll.ExpiresAt))
+	}
+}
+
+// link to a message of a public chat or a supergroup, basic groups have none
+func messageLink(chat *tb.Chat, messageID int) string {
+	if chat.Username != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", chat.Username, messageID)
+	}
+	if id, ok := strings.CutPrefix(strconv.FormatInt(chat.ID, 10), "-100"); ok {
+		return fmt.Sprintf("https://t.me/c/%s/%d", id, messageID)
+	}
+	return ""
+}
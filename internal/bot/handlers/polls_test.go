@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+func TestPollsListsOnlyRunningPolls(t *testing.T) {
+	ctx := newFakeContext(t)
+
+	expires := time.Now().Add(time.Hour)
+	for _, poll := range []*domain.ActivePoll{
+		{ID: "running", Type: "ban", UserID: 1, State: domain.PollStatePending},
+		{ID: "lifting", Type: "mute", UserID: 2, State: domain.PollStateLifting, LiftAt: expires},
+		{ID: "failed", Type: "kick", UserID: 3, State: domain.PollStateActing, DeadLetter: true},
+	} {
+		poll.ChatID = testChatID
+		poll.ExpiresAt = expires
+		if err := ctx.polls.SavePoll(poll); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := HandlePolls(ctx); err != nil {
+		t.Fatal(err)
+	}
+	list := ctx.api.last()
+	if !strings.Contains(list, "<code>1</code>") {
+		t.Errorf("running poll is not listed:\n%s", list)
+	}
+	if strings.Contains(list, "<code>2</code>") || strings.Contains(list, "<code>3</code>") {
+		t.Errorf("lifting or dead-lettered poll is listed:\n%s", list)
+	}
+
+	if err := ctx.polls.DeletePoll("running"); err != nil {
+		t.Fatal(err)
+	}
+	if err := HandlePolls(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := ctx.api.last(); got != "Сейчас голосований нет" {
+		t.Errorf("got %q with only finished polls left", got)
+	}
+}
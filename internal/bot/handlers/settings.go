@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
+	tb "gopkg.in/telebot.v4"
+)
+
+// unique of the settings menu buttons, routed to HandleSettingsButton
+const SettingsButtonUnique = "settings"
+
+// the menu buttons step through these values
+var (
+	pollDurationSteps = []time.Duration{
+		5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+		time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+	}
+	// 0 keeps the configured quorum rule
+	minVotesSteps = []int{0, 3, 5, 10, 20}
+	// everybody but restricted members, the step between admins and everyone
+	memberRoles = []tb.MemberStatus{tb.Creator, tb.Administrator, tb.Member}
+)
+
+type settingsLabels struct {
+	title        string
+	duration     string
+	minVotes     string
+	configured   string
+	access       string
+	admins       string
+	members      string
+	everyone     string
+	language     string
+	languageName string
+	filter       string
+	filterOff    string
+	updated      string
+	// replies to /settings filter, filterOn is formatted with the user and the percentage
+	filterStopped  string
+	badProbability string
+	filterOn       string
+}
+
+var settingsTexts = map[string]settingsLabels{
+	domain.LanguageRussian: {
+		title:        "Настройки чата",
+		duration:     "Длительность голосования",
+		minVotes:     "Минимум голосов",
+		configured:   "как настроено",
+		access:       "Начинать голосования могут",
+		admins:       "админы",
+		members:      "участники без ограничений",
+		everyone:     "все",
+		language:     "Язык",
+		languageName: "русский",
+		filter:       "Фильтр сообщений",
+		filterOff:    "выключен",
+		updated:      "Сохранено",
+
+		filterStopped:  "Фильтр сообщений выключен",
+		badProbability: "вероятность пиши числом от 0 до 1, например 0.6",
+		filterOn:       "Сообщения %s удаляются с вероятностью %.0f%%",
+	},
+	domain.LanguageEnglish: {
+		title:        "Chat settings",
+		duration:     "Poll duration",
+		minVotes:     "Minimum votes",
+		configured:   "default",
+		access:       "Votes can be started by",
+		admins:       "admins",
+		members:      "unrestricted members",
+		everyone:     "everyone",
+		language:     "Language",
+		languageName: "English",
+		filter:       "Message filter",
+		filterOff:    "off",
+		updated:      "Saved",
+
+		filterStopped:  "Message filter is off",
+		badProbability: "Probability is a number from 0 to 1, e.g. 0.6",
+		filterOn:       "Messages of %s are deleted with %.0f%% probability",
+	},
+}
+
+// shows the settings menu of the chat.
+// /settings filter <user> [probability] and /settings filter off set up the message filter
+func HandleSettings(ctx domain.Context) error {
+	if !ctx.Message().FromGroup() {
+		return ctx.Reply("команда работает только в группах")
+	}
+
+	if args := ctx.Args(); len(args) > 0 && args[0] == "filter" {
+		return handleFilterSettings(ctx, args[1:])
+	}
+
+	settings := ctx.Config().Settings(ctx.Chat().ID)
+	text, markup := renderSettings(ctx, settings)
+
+	_, err := ctx.BotAPI().Reply(ctx.Message(), text, &tb.SendOptions{
+		ParseMode:   tb.ModeHTML,
+		ReplyMarkup: markup,
+	})
+	return err
+}
+
+func handleFilterSettings(ctx domain.Context, args []string) error {
+	labels := labelsFor(ctx.Config().Settings(ctx.Chat().ID))
+
+	if len(args) == 1 && args[0] == "off" {
+		_, err := ctx.Config().UpdateSettings(ctx.Chat().ID, func(settings *domain.ChatSettings) {
+			settings.FilterUserID = 0
+		})
+		if err != nil {
+			return err
+		}
+		return ctx.Reply(labels.filterStopped)
+	}
+
+	user, rest, err := resolveTargetAfter(ctx, 1)
+	if err != nil {
+		return ctx.Reply(err.Error())
+	}
+
+	probability := ctx.Config().Defaults().FilterProbability
+	if len(rest) > 0 {
+		probability, err = strconv.ParseFloat(rest[0], 64)
+		if err != nil || probability < 0 || probability > 1 {
+			return ctx.Reply(labels.badProbability)
+		}
+	}
+
+	_, err = ctx.Config().UpdateSettings(ctx.Chat().ID, func(settings *domain.ChatSettings) {
+		settings.FilterUserID = user.ID
+		settings.FilterProbability = probability
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.Log().Info("message filter updated",
+		slog.Int64("user_id", user.ID),
+		slog.Float64("probability", probability),
+		slog.Int64("admin_id", ctx.Sender().ID))
+
+	return ctx.Reply(fmt.Sprintf(labels.filterOn, targetName(user), probability*100))
+}
+
+// changes the setting of the pressed button and redraws the menu
+func HandleSettingsButton(ctx domain.Context) error {
+	args := ctx.Args()
+	if len(args) == 0 {
+		return ctx.Respond()
+	}
+
+	var update func(*domain.ChatSettings)
+	switch args[0] {
+	case "duration":
+		update = func(settings *domain.ChatSettings) {
+			settings.PollDuration = nextStep(pollDurationSteps, settings.PollDuration)
+		}
+	case "votes":
+		update = func(settings *domain.ChatSettings) {
+			settings.MinVotes = nextStep(minVotesSteps, settings.MinVotes)
+		}
+	case "access":
+		update = func(settings *domain.ChatSettings) {
+			settings.VoteAccess = nextVoteAccess(settings.VoteAccess)
+		}
+	case "language":
+		update = func(settings *domain.ChatSettings) {
+			if settings.Language == domain.LanguageEnglish {
+				settings.Language = domain.LanguageRussian
+			} else {
+				settings.Language = domain.LanguageEnglish
+			}
+		}
+	case "kind":
+		if len(args) != 2 || ctx.PollKinds().Get(domain.PollType(args[1])) == nil {
+			return ctx.Respond()
+		}
+		pollType := domain.PollType(args[1])
+		update = func(settings *domain.ChatSettings) {
+			settings.SetPollTypeEnabled(pollType, !settings.PollTypeEnabled(pollType))
+		}
+	default:
+		return ctx.Respond()
+	}
+
+	settings, err := ctx.Config().UpdateSettings(ctx.Chat().ID, update)
+	if err != nil {
+		return err
+	}
+
+	ctx.Log().Info("chat setting changed",
+		slog.String("setting", args[0]),
+		slog.Int64("admin_id", ctx.Sender().ID))
+
+	text, markup := renderSettings(ctx, settings)
+	if err := ctx.Edit(text, &tb.SendOptions{
+		ParseMode:   tb.ModeHTML,
+		ReplyMarkup: markup,
+	}); err != nil {
+		return err
+	}
+
+	return ctx.Respond(&tb.CallbackResponse{Text: labelsFor(settings).updated})
+}
+
+func labelsFor(settings domain.ChatSettings) settingsLabels {
+	if labels, ok := settingsTexts[settings.Language]; ok {
+		return labels
+	}
+	return settingsTexts[domain.LanguageEnglish]
+}
+
+func renderSettings(ctx domain.Context, settings domain.ChatSettings) (string, *tb.ReplyMarkup) {
+	labels := labelsFor(settings)
+
+	minVotes := labels.configured
+	if settings.MinVotes > 0 {
+		minVotes = strconv.Itoa(settings.MinVotes)
+	}
+
+	access := voteAccessName(labels, settings.VoteAccess)
+
+	filter := labels.filterOff
+	if settings.FilterUserID != 0 {
+		filter = fmt.Sprintf("<code>%d</code>, %.0f%%", settings.FilterUserID, settings.FilterProbability*100)
+	}
+
+	duration := utils.FormatDuration(settings.PollDuration)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<b>%s</b>\n\n", labels.title)
+	fmt.Fprintf(&sb, "%s: %s\n", labels.duration, duration)
+	fmt.Fprintf(&sb, "%s: %s\n", labels.minVotes, minVotes)
+	fmt.Fprintf(&sb, "%s: %s\n", labels.access, access)
+	fmt.Fprintf(&sb, "%s: %s\n", labels.language, labels.languageName)
+	fmt.Fprintf(&sb, "%s: %s", labels.filter, filter)
+
+	markup := &tb.ReplyMarkup{}
+	rows := []tb.Row{
+		markup.Row(markup.Data(labels.duration+": "+duration, SettingsButtonUnique, "duration")),
+		markup.Row(markup.Data(labels.minVotes+": "+minVotes, SettingsButtonUnique, "votes")),
+		markup.Row(markup.Data(labels.access+": "+access, SettingsButtonUnique, "access")),
+		markup.Row(markup.Data(labels.language+": "+labels.languageName, SettingsButtonUnique, "language")),
+	}
+
+	// two poll kinds per row
+	var kindButtons []tb.Btn
+	for _, kind := range ctx.PollKinds().All() {
+		mark := "✅"
+		if !settings.PollTypeEnabled(kind.Type()) {
+			mark = "❌"
+		}
+		kindButtons = append(kindButtons, markup.Data(
+			mark+" /"+kind.Commands()[0], SettingsButtonUnique, "kind", string(kind.Type())))
+	}
+	for chunk := range slices.Chunk(kindButtons, 2) {
+		rows = append(rows, markup.Row(chunk...))
+	}
+
+	markup.Inline(rows...)
+	return sb.String(), markup
+}
+
+// admins, then unrestricted members, then everyone
+func nextVoteAccess(policy domain.AccessPolicy) domain.AccessPolicy {
+	switch {
+	case policy.Mode == domain.AccessAdmins:
+		return domain.AccessPolicy{Mode: domain.AccessRoles, Roles: slices.Clone(memberRoles)}
+	case policy.Mode == domain.AccessRoles && slices.Equal(policy.Roles, memberRoles):
+		return domain.AccessPolicy{Mode: domain.AccessEveryone}
+	default:
+		return domain.AccessPolicy{Mode: domain.AccessAdmins}
+	}
+}
+
+// roles other than the ones the menu sets come from VOTEBAN_VOTE_ACCESS and are listed as is
+func voteAccessName(labels settingsLabels, policy domain.AccessPolicy) string {
+	switch policy.Mode {
+	case domain.AccessAdmins:
+		return labels.admins
+	case domain.AccessEveryone:
+		return labels.everyone
+	}
+
+	if slices.Equal(policy.Roles, memberRoles) {
+		return labels.members
+	}
+
+	roles := make([]string, len(policy.Roles))
+	for i, role := range policy.Roles {
+		roles[i] = string(role)
+	}
+	return strings.Join(roles, ", ")
+}
+
+// the step after current, wrapping around to the first one
+func nextStep[T int | time.Duration](steps []T, current T) T {
+	for _, step := range steps {
+		if step > current {
+			return step
+		}
+	}
+	return steps[0]
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+// target of a command: the author of the replied message, a text mention,
+// a user id written as id:123 or an @username the bot has seen in the chat.
+// the arguments left after the target are returned for the command itself.
+// a user given by id or username only has the id and username filled in
+func resolveTarget(ctx domain.Context) (*tb.User, []string, error) {
+	return resolveTargetAfter(ctx, 0)
+}
+
+// same as resolveTarget for commands whose target follows skip arguments of their own
+func resolveTargetAfter(ctx domain.Context, skip int) (*tb.User, []string, error) {
+	msg := ctx.Message()
+	args := ctx.Args()
+	args = args[min(skip, len(args)):]
+
+	if reply := repliedMessage(msg); reply != nil && reply.Sender != nil {
+		return reply.Sender, args, nil
+	}
+
+	// users without a username can only be mentioned by name
+	for _, entity := range msg.Entities {
+		if entity.Type == tb.EntityTMention && entity.User != nil {
+			rest := strings.Fields(strings.Replace(msg.Payload, msg.EntityText(entity), "", 1))
+			return entity.User, rest[min(skip, len(rest)):], nil
+		}
+	}
+
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("ответь на сообщение или укажи юзера: @username или id:123")
+	}
+
+	target, rest := args[0], args[1:]
+
+	// only with the prefix, a bare number is more likely a mistyped duration
+	if idStr, ok := strings.CutPrefix(target, "id:"); ok {
+		userID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || userID <= 0 {
+			return nil, nil, fmt.Errorf("не понял id: %s", idStr)
+		}
+		return &tb.User{ID: userID}, rest, nil
+	}
+
+	if username, ok := strings.CutPrefix(target, "@"); ok && username != "" {
+		member, err := ctx.MemberStorage().FindByUsername(ctx.Chat().ID, username)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to find member: %w", err)
+		}
+		if member == nil {
+			return nil, nil, fmt.Errorf("не знаю, кто такой @%s, пусть сначала напишет что-нибудь в чат", username)
+		}
+		return &tb.User{ID: member.UserID, Username: member.Username}, rest, nil
+	}
+
+	return nil, nil, fmt.Errorf("не понял, кто это: %s", target)
+}
+
+// the message the user actually replied to. in forum topics every message
+// replies to the topic's service message, which is not a reply to anybody
+func repliedMessage(msg *tb.Message) *tb.Message {
+	reply := msg.ReplyTo
+	if reply == nil || reply.TopicCreated != nil || (msg.TopicMessage && reply.ID == msg.ThreadID) {
+		return nil
+	}
+	return reply
+}
+
+// how the target is called in messages
+func targetName(user *tb.User) string {
+	switch {
+	case user.Username != "":
+		return "@" + user.Username
+	case user.FirstName != "":
+		return strings.TrimSpace(user.FirstName + " " + user.LastName)
+	default:
+		return strconv.FormatInt(user.ID, 10)
+	}
+}
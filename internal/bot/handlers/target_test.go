@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	tb "gopkg.in/telebot.v4"
+)
+
+func TestResolveTarget(t *testing.T) {
+	ctx := newFakeContext(t)
+	seen := &tb.User{ID: 7, Username: "seen"}
+	if err := ctx.members.RecordMessage(testChatID, seen, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		reply   *tb.Message
+		wantID  int64
+		wantErr bool
+		rest    []string
+	}{
+		{name: "replied message", args: []string{"1d"}, reply: &tb.Message{ID: 2, Sender: &tb.User{ID: 5}}, wantID: 5, rest: []string{"1d"}},
+		{name: "prefixed id", args: []string{"id:123", "1d"}, wantID: 123, rest: []string{"1d"}},
+		{name: "bare number", args: []string{"5"}, wantErr: true},
+		{name: "bad id", args: []string{"id:abc"}, wantErr: true},
+		{name: "negative id", args: []string{"id:-5"}, wantErr: true},
+		{name: "seen username", args: []string{"@seen", "3h"}, wantID: 7, rest: []string{"3h"}},
+		{name: "unknown username", args: []string{"@stranger"}, wantErr: true},
+		{name: "nothing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx.message = &tb.Message{Chat: ctx.Chat(), Sender: ctx.Sender(), ReplyTo: tt.reply}
+			ctx.args = tt.args
+
+			user, rest, err := resolveTarget(ctx)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got user %d, want error", user.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != tt.wantID || !slices.Equal(rest, tt.rest) {
+				t.Errorf("got user %d and %v, want %d and %v", user.ID, rest, tt.wantID, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"slices"
+
+	tb "gopkg.in/telebot.v4"
+)
+
+type AccessMode string
+
+const (
+	AccessAdmins   AccessMode = "admins"
+	AccessEveryone AccessMode = "everyone"
+	// members whose role in the chat is one of AccessPolicy.Roles
+	AccessRoles AccessMode = "roles"
+)
+
+// who may run a command in a chat
+type AccessPolicy struct {
+	Mode  AccessMode        `json:"mode"`
+	Roles []tb.MemberStatus `json:"roles,omitempty"`
+}
+
+func (p AccessPolicy) AllowsRole(role tb.MemberStatus) bool {
+	switch p.Mode {
+	case AccessEveryone:
+		return true
+	case AccessRoles:
+		return slices.Contains(p.Roles, role)
+	default:
+		return role == tb.Creator || role == tb.Administrator
+	}
+}
+
+// who a command is meant for, the wrapper of every handler turns it into
+// the AccessPolicy of the chat and checks the sender against it
+type CommandAccess int
+
+const (
+	// help and commands that check access themselves
+	CommandPublic CommandAccess = iota
+	// starting votes, the vote access policy of the chat
+	CommandVote
+	// moderation and settings
+	CommandAdmin
+)
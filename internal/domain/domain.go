@@ -14,29 +14,143 @@ type Context interface {
 	Log() *slog.Logger
 	WithLogger(*slog.Logger) Context
 	PollStorage() PollStorage
+	PollHistory() PollHistory
 	StartPollMonitoring(*ActivePoll)
+	// deletes a dead-lettered poll and archives it with its last error
+	DropPoll(*ActivePoll) error
 	BotAPI() tb.API
+	AdminCache() AdminCache
+	PollKinds() *PollKinds
+	MemberStorage() MemberStorage
+	Config() ConfigProvider
+	PollGuard() PollGuard
+}
+
+// chat admin lists, kept for a while to save a request on every command
+type AdminCache interface {
+	AdminsOf(chat *tb.Chat) ([]tb.ChatMember, error)
+	Invalidate(chatID int64)
+	// fetches the list again unless the cached one is younger than minAge
+	Refresh(chat *tb.Chat, minAge time.Duration) ([]tb.ChatMember, error)
+}
+
+// limits on starting polls: one at a time per target, a cooldown after it
+// and a rate limit per initiator
+type PollGuard interface {
+	// holds the target's slot while the poll is being created, release tells
+	// whether it was. a *PollLimitError is for the user, anything else failed
+	Acquire(chatID, targetID, initiatorID int64, pollType PollType) (release func(created bool), err error)
+}
+
+// a poll that can't be started yet, the message says for how long
+type PollLimitError struct {
+	Message string
+	Wait    time.Duration
+}
+
+func (e *PollLimitError) Error() string {
+	return e.Message
 }
 
 type PollType string
 
 const (
-	PollTypeBan     PollType = "ban"
-	PollTypeUnban   PollType = "unban"
-	PollTypeGifs    PollType = "gifs"
-	PollTypeMedia   PollType = "media"
+	PollTypeBan    PollType = "ban"
+	PollTypeUnban  PollType = "unban"
+	PollTypeGifs   PollType = "gifs"
+	PollTypeMedia  PollType = "media"
+	PollTypeMute   PollType = "mute"
+	PollTypeUnmute PollType = "unmute"
+	PollTypeKick   PollType = "kick"
+	PollTypePunish PollType = "punish"
+)
+
+// processing step of an active poll, persisted before each step is taken
+// so a restart resumes where processing stopped instead of starting over
+type PollState string
+
+const (
+	// open and collecting votes
+	PollStatePending PollState = "pending"
+	// the telegram poll is being stopped, it may already be closed
+	PollStateStopping PollState = "stopping"
+	// Outcome is decided and its action is being applied
+	PollStateActing PollState = "acting"
+	// the action is temporary and is lifted at LiftAt
+	PollStateLifting PollState = "lifting"
+	// the action is applied, only archiving is left
+	PollStateDone PollState = "done"
 )
 
-// active poll that needs to be monitored
+// active poll that needs to be monitored.
+// TelegramPollID matches poll updates to it, ClosedEarly is set once
+// the outcome was decided before ExpiresAt, Votes is the ledger of who voted for what
 type ActivePoll struct {
-	ID           string    `json:"id"`
-	Type         PollType  `json:"type"`
-	ChatID       int64     `json:"chat_id"`
-	MessageID    int       `json:"message_id"`
-	UserID       int64     `json:"user_id"`
-	CreatedAt    time.Time `json:"created_at"`
-	ExpiresAt    time.Time `json:"expires_at"`
-	MemberData   []byte    `json:"member_data"`
+	ID             string    `json:"id"`
+	Type           PollType  `json:"type"`
+	ChatID         int64     `json:"chat_id"`
+	MessageID      int       `json:"message_id"`
+	TelegramPollID string    `json:"telegram_poll_id"`
+	UserID         int64     `json:"user_id"`
+	InitiatorID    int64     `json:"initiator_id"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	MemberData     []byte    `json:"member_data"`
+	ClosedEarly    bool      `json:"closed_early,omitempty"`
+	Votes          []Vote    `json:"votes,omitempty"`
+
+	// how long the action of a passed poll lasts, zero means forever.
+	// LiftAt is when it ends, set once the action is applied
+	ActionDuration time.Duration `json:"action_duration,omitempty"`
+	LiftAt         time.Time     `json:"lift_at,omitzero"`
+
+	State PollState `json:"state,omitempty"`
+	// raw tally from the last poll update, used when the telegram poll
+	// turns out to be already stopped after a restart
+	LastTally []PollOptionResult `json:"last_tally,omitempty"`
+	// set once the telegram poll is stopped, so a retry doesn't stop it again
+	Outcome *PollOutcome `json:"outcome,omitempty"`
+	// failed processing attempts, after too many the poll is dead-lettered
+	// and waits for an admin instead of being retried
+	Attempts   int    `json:"attempts,omitempty"`
+	LastError  string `json:"last_error,omitempty"`
+	DeadLetter bool   `json:"dead_letter,omitempty"`
+}
+
+// single entry of the poll vote ledger
+type Vote struct {
+	VoterID    int64     `json:"voter_id"`
+	VoterIsBot bool      `json:"voter_is_bot,omitempty"`
+	Option     int       `json:"option"`
+	VotedAt    time.Time `json:"voted_at"`
+}
+
+// records the voter's choice, replacing their previous one
+func (p *ActivePoll) RecordVote(vote Vote) {
+	p.RetractVote(vote.VoterID)
+	p.Votes = append(p.Votes, vote)
+}
+
+func (p *ActivePoll) RetractVote(voterID int64) {
+	votes := p.Votes[:0]
+	for _, vote := range p.Votes {
+		if vote.VoterID != voterID {
+			votes = append(votes, vote)
+		}
+	}
+	p.Votes = votes
+}
+
+// polls saved before the state was persisted have none
+func (p *ActivePoll) CurrentState() PollState {
+	switch {
+	case p.State != "":
+		return p.State
+	case p.Outcome != nil:
+		return PollStateActing
+	default:
+		return PollStatePending
+	}
 }
 
 type PollStorage interface {
@@ -44,4 +158,91 @@ type PollStorage interface {
 	GetPolls() ([]*ActivePoll, error)
 	DeletePoll(id string) error
 	GetPollsByType(pollType PollType) ([]*ActivePoll, error)
+	// polls of the chat, the ones expiring first go first
+	GetPollsByChat(chatID int64) ([]*ActivePoll, error)
+}
+
+type PollDecision string
+
+const (
+	PollDecisionPassed   PollDecision = "passed"
+	PollDecisionRejected PollDecision = "rejected"
+	PollDecisionTied     PollDecision = "tied"
+	PollDecisionNoQuorum PollDecision = "no_quorum"
+)
+
+// turnout and majority a poll needs for its result to count
+type QuorumRule struct {
+	// minimum number of votes cast
+	MinVotes int
+	// minimum share of chat members that have to vote, 0..1
+	MinShare float64
+	// share of votes the first option needs to pass, 0.5 is a simple majority
+	Supermajority float64
+}
+
+type PollOptionResult struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// what happened to a poll once it was closed.
+// Options is the raw tally from telegram, EligibleOptions only counts
+// votes that passed the eligibility rules and is what the decision is based on
+type PollOutcome struct {
+	Options         []PollOptionResult `json:"options"`
+	EligibleOptions []PollOptionResult `json:"eligible_options"`
+	RequiredVotes   int                `json:"required_votes"`
+	Decision        PollDecision       `json:"decision"`
+	// winning option of a multi-option poll
+	Choice int    `json:"choice,omitempty"`
+	Action string `json:"action"`
+}
+
+// finished poll kept in the history archive.
+// EligibleOptions is missing in records archived before eligibility rules existed
+type PollRecord struct {
+	PollID          string             `json:"poll_id"`
+	Type            PollType           `json:"type"`
+	ChatID          int64              `json:"chat_id"`
+	MessageID       int                `json:"message_id"`
+	UserID          int64              `json:"user_id"`
+	Username        string             `json:"username"`
+	InitiatorID     int64              `json:"initiator_id"`
+	CreatedAt       time.Time          `json:"created_at"`
+	FinishedAt      time.Time          `json:"finished_at"`
+	ClosedEarly     bool               `json:"closed_early,omitempty"`
+	ActionDuration  time.Duration      `json:"action_duration,omitempty"`
+	Options         []PollOptionResult `json:"options"`
+	EligibleOptions []PollOptionResult `json:"eligible_options,omitempty"`
+	Decision        PollDecision       `json:"decision"`
+	Action          string             `json:"action"`
+	Error           string             `json:"error,omitempty"`
+}
+
+// append-only archive of finished polls, records are returned newest first
+type PollHistory interface {
+	AddRecord(record *PollRecord) error
+	GetRecordsByChat(chatID int64, limit, offset int) ([]*PollRecord, error)
+	GetRecordsByUser(chatID, userID int64, limit, offset int) ([]*PollRecord, error)
+}
+
+// what the bot knows about a chat member from the messages it has seen
+type MemberActivity struct {
+	ChatID       int64     `json:"chat_id"`
+	UserID       int64     `json:"user_id"`
+	Username     string    `json:"username"`
+	IsBot        bool      `json:"is_bot"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	MessageCount int       `json:"message_count"`
+}
+
+type MemberStorage interface {
+	RecordMessage(chatID int64, user *tb.User, at time.Time) error
+	// returns nil if the bot has never seen the user in the chat
+	GetMember(chatID, userID int64) (*MemberActivity, error)
+	// case-insensitive, without the @. returns nil if nobody the bot has
+	// seen in the chat goes by that username
+	FindByUsername(chatID int64, username string) (*MemberActivity, error)
 }
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"time"
+
+	tb "gopkg.in/telebot.v4"
+)
+
+// what a passed poll acts on
+type PollAction struct {
+	// the poll the action comes from, a lift ends what it applied
+	PollID string
+	// the poll message, replies go to it
+	Message *tb.Message
+	Member  *tb.ChatMember
+	// zero for permanent actions
+	Until time.Time
+	// tally text to append to the announcement
+	Summary string
+	// winning option of a multi-option poll
+	Option int
+}
+
+// everything that differs between poll types. commands, help text and
+// processing are all built from the registered kinds
+type PollKind interface {
+	Type() PollType
+	// commands starting the poll without the slash, the first one is shown in help
+	Commands() []string
+	Help() string
+	// name of the poll type in listings
+	Name() string
+	Question() string
+	// the first option is the one that passes the poll
+	Options() []string
+	// whether the poll takes a duration argument, like /ban 1d
+	TakesDuration() bool
+	// checked before the poll is created, the error is shown to the user
+	Validate(target *tb.ChatMember) error
+	// acts on a passed poll. the announcement is only sent once the action is
+	// saved as applied, so a failed reply doesn't get the action repeated
+	Apply(action PollAction) (PollActionResult, error)
+	// undoes a temporary action once it is over and returns its announcement
+	Lift(action PollAction) (string, error)
+}
+
+// Action is recorded in the history, Announcement is replied to the poll
+type PollActionResult struct {
+	Action       string
+	Announcement string
+}
+
+// poll kind with more than a yes/no answer, every option but the
+// first one ("nothing") is an action of its own
+type MultiOptionKind interface {
+	PollKind
+	// picks the winning option from the eligible tally, -1 when nothing won
+	Decide(required int, options []PollOptionResult) (PollDecision, int)
+	// how long the action of the option lasts, zero for permanent ones
+	OptionDuration(option int) time.Duration
+}
+
+// poll kinds in the order they are listed in help
+type PollKinds struct {
+	kinds  []PollKind
+	byType map[PollType]PollKind
+}
+
+func NewPollKinds(kinds ...PollKind) *PollKinds {
+	registry := &PollKinds{byType: make(map[PollType]PollKind, len(kinds))}
+	for _, kind := range kinds {
+		registry.kinds = append(registry.kinds, kind)
+		registry.byType[kind.Type()] = kind
+	}
+	return registry
+}
+
+func (k *PollKinds) All() []PollKind {
+	return k.kinds
+}
+
+// returns nil for unknown types
+func (k *PollKinds) Get(pollType PollType) PollKind {
+	return k.byType[pollType]
+}
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	LanguageRussian = "ru"
+	LanguageEnglish = "en"
+)
+
+// per-chat configuration, editable by chat admins with /settings.
+// a chat without saved settings uses the process-wide defaults
+type ChatSettings struct {
+	ChatID       int64         `json:"chat_id"`
+	PollDuration time.Duration `json:"poll_duration"`
+	// overrides the minimum number of votes of every poll type, 0 keeps the configured rule
+	MinVotes          int        `json:"min_votes,omitempty"`
+	DisabledPollTypes []PollType `json:"disabled_poll_types,omitempty"`
+	Language          string     `json:"language"`
+	// who may start votes
+	VoteAccess AccessPolicy `json:"vote_access"`
+	// messages of this user are deleted with the probability, 0 disables the filter
+	FilterUserID      int64   `json:"filter_user_id,omitempty"`
+	FilterProbability float64 `json:"filter_probability"`
+}
+
+func (s *ChatSettings) PollTypeEnabled(pollType PollType) bool {
+	return !slices.Contains(s.DisabledPollTypes, pollType)
+}
+
+func (s *ChatSettings) SetPollTypeEnabled(pollType PollType, enabled bool) {
+	s.DisabledPollTypes = slices.DeleteFunc(s.DisabledPollTypes, func(t PollType) bool {
+		return t == pollType
+	})
+	if !enabled {
+		s.DisabledPollTypes = append(s.DisabledPollTypes, pollType)
+	}
+}
+
+func (s ChatSettings) Clone() ChatSettings {
+	s.DisabledPollTypes = slices.Clone(s.DisabledPollTypes)
+	s.VoteAccess.Roles = slices.Clone(s.VoteAccess.Roles)
+	return s
+}
+
+type SettingsStorage interface {
+	// returns nil if the chat has no saved settings
+	GetSettings(chatID int64) (*ChatSettings, error)
+	SaveSettings(settings *ChatSettings) error
+}
+
+// chat settings as services see them, with the defaults filled in
+type ConfigProvider interface {
+	Settings(chatID int64) ChatSettings
+	// settings of a chat that never changed them
+	Defaults() ChatSettings
+	// applies update to the current settings of the chat and saves them
+	UpdateSettings(chatID int64, update func(*ChatSettings)) (ChatSettings, error)
+}
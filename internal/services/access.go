@@ -0,0 +1,90 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+// decides who may run which command, checked by the wrapper of every handler
+type AccessService struct {
+	bot    tb.API
+	logger *slog.Logger
+	admins domain.AdminCache
+	config domain.ConfigProvider
+	// every command but the public ones is for admins only, whatever the chat settings say
+	adminsOnly bool
+}
+
+func NewAccessService(bot tb.API, logger *slog.Logger, admins domain.AdminCache, config domain.ConfigProvider) *AccessService {
+	service := &AccessService{
+		bot:    bot,
+		logger: logger,
+		admins: admins,
+		config: config,
+	}
+
+	// conf
+	if adminsOnlyStr := os.Getenv("ADMINS_ONLY"); adminsOnlyStr != "" {
+		if adminsOnly, err := strconv.ParseBool(adminsOnlyStr); err == nil {
+			service.adminsOnly = adminsOnly
+		} else {
+			logger.Warn("invalid ADMINS_ONLY in environment", slog.String("value", adminsOnlyStr))
+		}
+	}
+
+	return service
+}
+
+// public commands stay public under ADMINS_ONLY, /refreshadmins is how
+// a member promoted a moment ago gets their admin rights recognized
+func (s *AccessService) Policy(chatID int64, access domain.CommandAccess) domain.AccessPolicy {
+	switch {
+	case access == domain.CommandPublic:
+		return domain.AccessPolicy{Mode: domain.AccessEveryone}
+	case s.adminsOnly || access == domain.CommandAdmin:
+		return domain.AccessPolicy{Mode: domain.AccessAdmins}
+	default:
+		return s.config.Settings(chatID).VoteAccess
+	}
+}
+
+// whether the user may run a command meant for access in the chat
+func (s *AccessService) Allowed(chat *tb.Chat, user *tb.User, access domain.CommandAccess) (bool, error) {
+	policy := s.Policy(chat.ID, access)
+	if policy.Mode == domain.AccessEveryone {
+		return true, nil
+	}
+
+	// nobody to moderate in private chats, the commands refuse to work there themselves
+	if chat.Type == tb.ChatPrivate {
+		return true, nil
+	}
+
+	admins, err := s.admins.AdminsOf(chat)
+	if err != nil {
+		return false, fmt.Errorf("failed to get admins: %w", err)
+	}
+
+	for _, admin := range admins {
+		if admin.User.ID == user.ID {
+			return policy.AllowsRole(admin.Role), nil
+		}
+	}
+
+	if policy.Mode != domain.AccessRoles {
+		return false, nil
+	}
+
+	// the admin list settles it for admins, everybody else has to be looked up
+	member, err := s.bot.ChatMemberOf(chat, user)
+	if err != nil {
+		return false, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	return policy.AllowsRole(member.Role), nil
+}
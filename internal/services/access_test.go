@@ -0,0 +1,57 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+// the same settings for every chat
+type staticConfig struct {
+	settings domain.ChatSettings
+}
+
+func (c staticConfig) Settings(chatID int64) domain.ChatSettings { return c.settings }
+func (c staticConfig) Defaults() domain.ChatSettings             { return c.settings }
+
+func (c staticConfig) UpdateSettings(chatID int64, update func(*domain.ChatSettings)) (domain.ChatSettings, error) {
+	update(&c.settings)
+	return c.settings, nil
+}
+
+func TestAccessPolicy(t *testing.T) {
+	everyone := domain.AccessPolicy{Mode: domain.AccessEveryone}
+	admins := domain.AccessPolicy{Mode: domain.AccessAdmins}
+
+	tests := []struct {
+		name       string
+		adminsOnly bool
+		access     domain.CommandAccess
+		want       domain.AccessMode
+	}{
+		{"public", false, domain.CommandPublic, domain.AccessEveryone},
+		{"vote follows the chat", false, domain.CommandVote, domain.AccessEveryone},
+		{"admin", false, domain.CommandAdmin, domain.AccessAdmins},
+		{"public under ADMINS_ONLY", true, domain.CommandPublic, domain.AccessEveryone},
+		{"vote under ADMINS_ONLY", true, domain.CommandVote, domain.AccessAdmins},
+		{"admin under ADMINS_ONLY", true, domain.CommandAdmin, domain.AccessAdmins},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &AccessService{
+				config:     staticConfig{settings: domain.ChatSettings{VoteAccess: everyone}},
+				adminsOnly: tt.adminsOnly,
+			}
+			if got := s.Policy(testChatID, tt.access); got.Mode != tt.want {
+				t.Errorf("Policy() = %s, want %s", got.Mode, tt.want)
+			}
+		})
+	}
+
+	// the vote policy of the chat applies as it is
+	s := &AccessService{config: staticConfig{settings: domain.ChatSettings{VoteAccess: admins}}}
+	if got := s.Policy(testChatID, domain.CommandVote); got.Mode != domain.AccessAdmins {
+		t.Errorf("vote Policy() = %s, want the chat's %s", got.Mode, domain.AccessAdmins)
+	}
+}
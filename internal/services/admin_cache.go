@@ -0,0 +1,108 @@
+package services
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v4"
+)
+
+type AdminCacheService struct {
+	bot    tb.API
+	logger *slog.Logger
+	ttl    time.Duration
+
+	mutex   sync.Mutex
+	entries map[int64]adminCacheEntry
+}
+
+type adminCacheEntry struct {
+	admins    []tb.ChatMember
+	fetchedAt time.Time
+}
+
+func NewAdminCacheService(bot tb.API, logger *slog.Logger) *AdminCacheService {
+	service := &AdminCacheService{
+		bot:     bot,
+		logger:  logger,
+		ttl:     10 * time.Minute, // def
+		entries: make(map[int64]adminCacheEntry),
+	}
+
+	// conf
+	if ttlStr := os.Getenv("VOTEBAN_ADMIN_CACHE_TTL"); ttlStr != "" {
+		if ttl, err := time.ParseDuration(ttlStr); err == nil && ttl >= 0 {
+			service.ttl = ttl
+		} else {
+			logger.Warn("invalid VOTEBAN_ADMIN_CACHE_TTL in environment", slog.String("value", ttlStr))
+		}
+	}
+
+	return service
+}
+
+// admins of the chat, fetched from telegram when the cached list is missing or stale
+func (s *AdminCacheService) AdminsOf(chat *tb.Chat) ([]tb.ChatMember, error) {
+	s.mutex.Lock()
+	entry, ok := s.entries[chat.ID]
+	s.mutex.Unlock()
+
+	if ok && time.Since(entry.fetchedAt) < s.ttl {
+		return entry.admins, nil
+	}
+
+	admins, err := s.bot.AdminsOf(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.entries[chat.ID] = adminCacheEntry{admins: admins, fetchedAt: time.Now()}
+	s.mutex.Unlock()
+
+	s.logger.Debug("admin list fetched",
+		slog.Int64("chat_id", chat.ID),
+		slog.Int("count", len(admins)))
+
+	return admins, nil
+}
+
+// drops the cached list, the next lookup goes to telegram
+func (s *AdminCacheService) Invalidate(chatID int64) {
+	s.mutex.Lock()
+	delete(s.entries, chatID)
+	s.mutex.Unlock()
+
+	s.logger.Debug("admin list invalidated", slog.Int64("chat_id", chatID))
+}
+
+// minAge keeps anybody from making a request to telegram on every call
+func (s *AdminCacheService) Refresh(chat *tb.Chat, minAge time.Duration) ([]tb.ChatMember, error) {
+	s.mutex.Lock()
+	entry, ok := s.entries[chat.ID]
+	if ok && time.Since(entry.fetchedAt) < minAge {
+		s.mutex.Unlock()
+		return entry.admins, nil
+	}
+	delete(s.entries, chat.ID)
+	s.mutex.Unlock()
+
+	return s.AdminsOf(chat)
+}
+
+// invalidates the chat when somebody becomes or stops being an admin, including the bot itself
+func (s *AdminCacheService) HandleChatMemberUpdate(update *tb.ChatMemberUpdate) {
+	if update == nil || update.Chat == nil {
+		return
+	}
+
+	if isAdminRole(update.OldChatMember) || isAdminRole(update.NewChatMember) {
+		s.Invalidate(update.Chat.ID)
+	}
+}
+
+func isAdminRole(member *tb.ChatMember) bool {
+	return member != nil && (member.Role == tb.Administrator || member.Role == tb.Creator)
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+// implements ConfigProvider: chat settings from storage, the environment for
+// chats that have none. settings are cached, the message filter reads them on every message
+type ConfigService struct {
+	logger   *slog.Logger
+	storage  domain.SettingsStorage
+	defaults domain.ChatSettings
+
+	mutex sync.Mutex
+	cache map[int64]domain.ChatSettings
+}
+
+func NewConfigService(storage domain.SettingsStorage, logger *slog.Logger) *ConfigService {
+	service := &ConfigService{
+		logger:  logger,
+		storage: storage,
+		defaults: domain.ChatSettings{
+			PollDuration:      30 * time.Minute, // def
+			Language:          domain.LanguageEnglish,
+			VoteAccess:        domain.AccessPolicy{Mode: domain.AccessEveryone},
+			FilterProbability: 0.6,
+		},
+		cache: make(map[int64]domain.ChatSettings),
+	}
+
+	// conf: defaults of chats that never changed their settings
+	if durationStr := os.Getenv("VOTEBAN_POLL_DURATION_SECONDS"); durationStr != "" {
+		if seconds, err := strconv.Atoi(durationStr); err == nil && seconds > 0 {
+			service.defaults.PollDuration = time.Duration(seconds) * time.Second
+		} else {
+			logger.Warn("invalid VOTEBAN_POLL_DURATION_SECONDS in environment", slog.String("value", durationStr))
+		}
+	}
+
+	if language := os.Getenv("VOTEBAN_LANGUAGE"); language != "" {
+		if language == domain.LanguageRussian || language == domain.LanguageEnglish {
+			service.defaults.Language = language
+		} else {
+			logger.Warn("invalid VOTEBAN_LANGUAGE in environment", slog.String("value", language))
+		}
+	}
+
+	if accessStr := os.Getenv("VOTEBAN_VOTE_ACCESS"); accessStr != "" {
+		if policy, err := parseAccessPolicy(accessStr); err == nil {
+			service.defaults.VoteAccess = policy
+		} else {
+			logger.Warn("invalid VOTEBAN_VOTE_ACCESS in environment", slog.String("value", accessStr))
+		}
+	}
+
+	if userIDStr := os.Getenv("TARGET_USER_ID"); userIDStr != "" {
+		if userID, err := strconv.ParseInt(userIDStr, 10, 64); err == nil {
+			service.defaults.FilterUserID = userID
+		} else {
+			logger.Warn("invalid TARGET_USER_ID in environment", slog.String("value", userIDStr))
+		}
+	}
+
+	if probStr := os.Getenv("DELETION_PROBABILITY"); probStr != "" {
+		if prob, err := strconv.ParseFloat(probStr, 64); err == nil && prob >= 0 && prob <= 1 {
+			service.defaults.FilterProbability = prob
+		} else {
+			logger.Warn("invalid DELETION_PROBABILITY in environment", slog.String("value", probStr))
+		}
+	}
+
+	return service
+}
+
+func (s *ConfigService) Settings(chatID int64) domain.ChatSettings {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.load(chatID).Clone()
+}
+
+func (s *ConfigService) Defaults() domain.ChatSettings {
+	return s.defaults.Clone()
+}
+
+func (s *ConfigService) UpdateSettings(chatID int64, update func(*domain.ChatSettings)) (domain.ChatSettings, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	settings := s.load(chatID).Clone()
+	update(&settings)
+	settings.ChatID = chatID
+
+	if err := s.storage.SaveSettings(&settings); err != nil {
+		return domain.ChatSettings{}, fmt.Errorf("failed to save settings: %w", err)
+	}
+
+	s.cache[chatID] = settings
+	s.logger.Info("chat settings updated", slog.Int64("chat_id", chatID))
+
+	return settings.Clone(), nil
+}
+
+// callers hold the mutex. a storage error falls back to the defaults without caching them
+func (s *ConfigService) load(chatID int64) domain.ChatSettings {
+	if settings, ok := s.cache[chatID]; ok {
+		return settings
+	}
+
+	settings := s.defaults
+	settings.ChatID = chatID
+
+	stored, err := s.storage.GetSettings(chatID)
+	if err != nil {
+		s.logger.Error("failed to load chat settings, using defaults",
+			slog.Int64("chat_id", chatID),
+			slog.String("error", err.Error()))
+		return settings
+	}
+	if stored != nil {
+		settings = *stored
+	}
+
+	s.cache[chatID] = settings
+	return settings
+}
+
+// admins, everyone or a comma-separated list of chat roles: creator, administrator, member, restricted
+func parseAccessPolicy(value string) (domain.AccessPolicy, error) {
+	switch mode := domain.AccessMode(strings.TrimSpace(value)); mode {
+	case domain.AccessAdmins, domain.AccessEveryone:
+		return domain.AccessPolicy{Mode: mode}, nil
+	}
+
+	policy := domain.AccessPolicy{Mode: domain.AccessRoles}
+	for _, role := range strings.Split(value, ",") {
+		switch role := tb.MemberStatus(strings.TrimSpace(role)); role {
+		case tb.Creator, tb.Administrator, tb.Member, tb.Restricted:
+			policy.Roles = append(policy.Roles, role)
+		default:
+			return domain.AccessPolicy{}, fmt.Errorf("unknown chat role: %s", role)
+		}
+	}
+
+	return policy, nil
+}
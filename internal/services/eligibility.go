@@ -0,0 +1,122 @@
+package services
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+// decides which votes of the ledger count towards the poll result
+type EligibilityService struct {
+	members     domain.MemberStorage
+	logger      *slog.Logger
+	minAge      time.Duration
+	minMessages int
+}
+
+func NewEligibilityService(members domain.MemberStorage, logger *slog.Logger) *EligibilityService {
+	service := &EligibilityService{
+		members: members,
+		logger:  logger,
+	}
+
+	// conf
+	if ageStr := os.Getenv("VOTEBAN_VOTER_MIN_AGE"); ageStr != "" {
+		if age, err := time.ParseDuration(ageStr); err == nil && age >= 0 {
+			service.minAge = age
+		} else {
+			logger.Warn("invalid VOTEBAN_VOTER_MIN_AGE in environment", slog.String("value", ageStr))
+		}
+	}
+
+	if messagesStr := os.Getenv("VOTEBAN_VOTER_MIN_MESSAGES"); messagesStr != "" {
+		if messages, err := strconv.Atoi(messagesStr); err == nil && messages >= 0 {
+			service.minMessages = messages
+		} else {
+			logger.Warn("invalid VOTEBAN_VOTER_MIN_MESSAGES in environment", slog.String("value", messagesStr))
+		}
+	}
+
+	return service
+}
+
+// counts the ledger votes that pass the rules, options gives the texts and number of options.
+// polls created before the ledger existed have no votes recorded, their raw tally is used as is
+func (s *EligibilityService) EligibleTally(poll *domain.ActivePoll, options []domain.PollOptionResult) []domain.PollOptionResult {
+	tally := make([]domain.PollOptionResult, len(options))
+	rawTotal := 0
+	for i, option := range options {
+		tally[i] = domain.PollOptionResult{Text: option.Text}
+		rawTotal += option.Votes
+	}
+
+	if len(poll.Votes) == 0 && rawTotal > 0 {
+		s.logger.Warn("poll has no vote ledger, counting all votes",
+			slog.String("poll_id", poll.ID))
+		copy(tally, options)
+		return tally
+	}
+
+	for _, vote := range poll.Votes {
+		if vote.Option < 0 || vote.Option >= len(tally) {
+			continue
+		}
+
+		if reason := s.ineligibleReason(poll, vote); reason != "" {
+			s.logger.Debug("vote not counted",
+				slog.String("poll_id", poll.ID),
+				slog.Int64("voter_id", vote.VoterID),
+				slog.String("reason", reason))
+			continue
+		}
+
+		tally[vote.Option].Votes++
+	}
+
+	return tally
+}
+
+// why the vote doesn't count, empty if it does
+func (s *EligibilityService) ineligibleReason(poll *domain.ActivePoll, vote domain.Vote) string {
+	if vote.VoterID == poll.UserID {
+		return "target"
+	}
+
+	if vote.VoterIsBot {
+		return "bot"
+	}
+
+	if s.minAge == 0 && s.minMessages == 0 {
+		return ""
+	}
+
+	member, err := s.members.GetMember(poll.ChatID, vote.VoterID)
+	if err != nil {
+		// not knowing is not a reason to throw the vote away
+		s.logger.Error("failed to get member activity",
+			slog.Int64("voter_id", vote.VoterID),
+			slog.String("error", err.Error()))
+		return ""
+	}
+
+	if member == nil {
+		return "never seen in chat"
+	}
+
+	if member.IsBot {
+		return "bot"
+	}
+
+	if vote.VotedAt.Sub(member.FirstSeen) < s.minAge {
+		return "joined too recently"
+	}
+
+	if member.MessageCount < s.minMessages {
+		return "too few messages"
+	}
+
+	return ""
+}
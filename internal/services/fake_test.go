@@ -0,0 +1,121 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+// telegram as the tests see it, calls nobody set up panic on the nil embedded api
+type fakeAPI struct {
+	tb.API
+
+	mutex sync.Mutex
+	// the member ChatMemberOf returns
+	member *tb.ChatMember
+	// default permissions of the chat, nil for none
+	chatRights *tb.Rights
+	restricted []tb.ChatMember
+	replies    []string
+	// returned by Reply
+	replyErr error
+
+	// votes StopPoll closes the poll with, for yes and no
+	tally    [2]int
+	banned   []int64
+	unbanned []int64
+	// returned by Ban
+	banErr error
+}
+
+func (a *fakeAPI) ChatMemberOf(chat, user tb.Recipient) (*tb.ChatMember, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	member := *a.member
+	return &member, nil
+}
+
+func (a *fakeAPI) ChatByID(id int64) (*tb.Chat, error) {
+	return &tb.Chat{ID: id, Permissions: a.chatRights}, nil
+}
+
+func (a *fakeAPI) Restrict(chat *tb.Chat, member *tb.ChatMember) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.restricted = append(a.restricted, *member)
+	a.member = &tb.ChatMember{User: member.User, Role: tb.Restricted, Rights: member.Rights, RestrictedUntil: member.RestrictedUntil}
+	return nil
+}
+
+func (a *fakeAPI) Reply(to *tb.Message, what any, opts ...any) (*tb.Message, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.replies = append(a.replies, what.(string))
+	return &tb.Message{}, a.replyErr
+}
+
+func (a *fakeAPI) StopPoll(msg tb.Editable, opts ...any) (*tb.Poll, error) {
+	return &tb.Poll{Closed: true, Options: []tb.PollOption{
+		{Text: "Да", VoterCount: a.tally[0]},
+		{Text: "Нет", VoterCount: a.tally[1]},
+	}}, nil
+}
+
+func (a *fakeAPI) Len(chat *tb.Chat) (int, error) {
+	return 100, nil
+}
+
+func (a *fakeAPI) Ban(chat *tb.Chat, member *tb.ChatMember, revokeMessages ...bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.banErr != nil {
+		return a.banErr
+	}
+	a.banned = append(a.banned, member.User.ID)
+	return nil
+}
+
+func (a *fakeAPI) Unban(chat *tb.Chat, user *tb.User, forBanned ...bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.unbanned = append(a.unbanned, user.ID)
+	return nil
+}
+
+func (a *fakeAPI) lastRestriction() tb.ChatMember {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.restricted[len(a.restricted)-1]
+}
+
+// poll history in memory, newest first like the real ones
+type memoryHistory struct {
+	records []*domain.PollRecord
+}
+
+func (h *memoryHistory) AddRecord(record *domain.PollRecord) error {
+	h.records = append([]*domain.PollRecord{record}, h.records...)
+	return nil
+}
+
+func (h *memoryHistory) GetRecordsByChat(chatID int64, limit, offset int) ([]*domain.PollRecord, error) {
+	return h.filter(func(record *domain.PollRecord) bool { return record.ChatID == chatID }, limit, offset), nil
+}
+
+func (h *memoryHistory) GetRecordsByUser(chatID, userID int64, limit, offset int) ([]*domain.PollRecord, error) {
+	return h.filter(func(record *domain.PollRecord) bool {
+		return record.ChatID == chatID && record.UserID == userID
+	}, limit, offset), nil
+}
+
+func (h *memoryHistory) filter(match func(*domain.PollRecord) bool, limit, offset int) []*domain.PollRecord {
+	var records []*domain.PollRecord
+	for _, record := range h.records {
+		if match(record) {
+			records = append(records, record)
+		}
+	}
+	records = records[min(offset, len(records)):]
+	return records[:min(limit, len(records))]
+}
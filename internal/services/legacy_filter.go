@@ -1,6 +1,7 @@
 package services
 
 import (
+	"errors"
 	"log/slog"
 	"math/rand"
 	"time"
@@ -28,7 +29,11 @@ func (s *LegacyMessageFilter) HandleLegacyMessage(msg *tb.Message) error {
 	if msg.Sender.ID == historicalUserID {
 		r := rand.New(rand.NewSource(time.Now().UnixNano()))
 		if r.Float64() < 0.6 {
-			if err := s.bot.Delete(msg); err != nil {
+			if err := s.bot.Delete(msg); errors.Is(err, ErrRateLimited) {
+				s.logger.Debug("message deletion dropped by rate limiter",
+					slog.Int64("user_id", msg.Sender.ID),
+					slog.Int64("message_id", int64(msg.ID)))
+			} else if err != nil {
 				s.logger.Error("failed to delete message",
 					slog.Int64("user_id", msg.Sender.ID),
 					slog.Int64("message_id", int64(msg.ID)),
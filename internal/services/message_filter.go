@@ -1,49 +1,29 @@
 package services
 
 import (
+	"errors"
 	"log/slog"
 	"math/rand"
-	"os"
-	"strconv"
 	"time"
 
+	"github.com/uaru-shit/votes/internal/domain"
 	tb "gopkg.in/telebot.v4"
 )
 
 type MessageFilterService struct {
-	bot               tb.API
-	logger            *slog.Logger
-	targetUserID      int64
-	deletionProbability float64
-	legacyFilter      *LegacyMessageFilter
+	bot          tb.API
+	logger       *slog.Logger
+	config       domain.ConfigProvider
+	legacyFilter *LegacyMessageFilter
 }
 
-func NewMessageFilterService(bot tb.API, logger *slog.Logger) *MessageFilterService {
-	service := &MessageFilterService{
-		bot:                 bot,
-		logger:              logger,
-		deletionProbability: 0.6, // def
-		legacyFilter:        NewLegacyMessageFilter(bot, logger),
+func NewMessageFilterService(bot tb.API, logger *slog.Logger, config domain.ConfigProvider) *MessageFilterService {
+	return &MessageFilterService{
+		bot:          bot,
+		logger:       logger,
+		config:       config,
+		legacyFilter: NewLegacyMessageFilter(bot, logger),
 	}
-
-	// conf
-	if userIDStr := os.Getenv("TARGET_USER_ID"); userIDStr != "" {
-		if userID, err := strconv.ParseInt(userIDStr, 10, 64); err == nil {
-			service.targetUserID = userID
-		} else {
-			logger.Warn("invalid TARGET_USER_ID in environment", slog.String("value", userIDStr))
-		}
-	}
-
-	if probStr := os.Getenv("DELETION_PROBABILITY"); probStr != "" {
-		if prob, err := strconv.ParseFloat(probStr, 64); err == nil && prob >= 0 && prob <= 1 {
-			service.deletionProbability = prob
-		} else {
-			logger.Warn("invalid DELETION_PROBABILITY in environment", slog.String("value", probStr))
-		}
-	}
-
-	return service
 }
 
 func (s *MessageFilterService) HandleMessage(msg *tb.Message) error {
@@ -51,13 +31,19 @@ func (s *MessageFilterService) HandleMessage(msg *tb.Message) error {
 		return err
 	}
 
-	if s.targetUserID == 0 || msg.Sender.ID != s.targetUserID {
+	settings := s.config.Settings(msg.Chat.ID)
+	if settings.FilterUserID == 0 || msg.Sender.ID != settings.FilterUserID {
 		return nil
 	}
 
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	if r.Float64() < s.deletionProbability {
-		if err := s.bot.Delete(msg); err != nil {
+	if r.Float64() < settings.FilterProbability {
+		if err := s.bot.Delete(msg); errors.Is(err, ErrRateLimited) {
+			s.logger.Debug("message deletion dropped by rate limiter",
+				slog.Int64("user_id", msg.Sender.ID),
+				slog.Int64("message_id", int64(msg.ID)))
+			return nil
+		} else if err != nil {
 			s.logger.Error("failed to delete message",
 				slog.Int64("user_id", msg.Sender.ID),
 				slog.Int64("message_id", int64(msg.ID)),
@@ -3,69 +3,300 @@ package services
 import (
 	"fmt"
 	"log/slog"
+	"time"
 
+	"github.com/uaru-shit/votes/internal/domain"
 	tb "gopkg.in/telebot.v4"
 )
 
+const (
+	// poll history records looked through for restrictions still in force
+	restrictionHistoryLimit = 100
+	// telegram takes restrictions shorter than this as forever,
+	// one ending sooner is as good as over
+	minRestriction = 30 * time.Second
+)
+
+// permission each restricting action takes away
+var actionPermissions = map[string]string{
+	actionRestrictGifs:  "CanSendOther",
+	actionRestrictMedia: "CanSendMedia",
+	actionMute:          "CanSendMessages",
+}
+
 type PermissionService struct {
-	bot    tb.API
-	logger *slog.Logger
+	bot     tb.API
+	logger  *slog.Logger
+	history domain.PollHistory
 }
 
-func NewPermissionService(bot tb.API, logger *slog.Logger) *PermissionService {
+func NewPermissionService(bot tb.API, logger *slog.Logger, history domain.PollHistory) *PermissionService {
 	return &PermissionService{
-		bot:    bot,
-		logger: logger,
+		bot:     bot,
+		logger:  logger,
+		history: history,
 	}
 }
 
-func (s *PermissionService) UpdatePermission(msg *tb.Message, member *tb.ChatMember, permission string, value bool, errorMsg, successMsg string) error {
-	currentMember, err := s.bot.ChatMemberOf(msg.Chat, member.User)
+// restriction applied by a passed poll, zero until is forever
+type appliedRestriction struct {
+	pollID     string
+	permission string
+	until      time.Time
+}
+
+// zero until of the action is forever. the member stays restricted until
+// the last of the restrictions in force ends, lift jobs end the earlier ones
+func (s *PermissionService) RestrictPermission(action domain.PollAction, permission, errorMsg string) error {
+	msg := action.Message
+	currentMember, err := s.bot.ChatMemberOf(msg.Chat, action.Member.User)
 	if err != nil {
 		s.logger.Error("cannot get current member data", slog.String("error", err.Error()))
-		_, replyErr := s.bot.Reply(msg, "Чота не могу получить данные юзера")
-		if replyErr != nil {
-			s.logger.Error("failed to send error message", slog.String("error", replyErr.Error()))
+		s.replyError(msg, "Чота не могу получить данные юзера")
+		return err
+	}
+
+	// telegram only sends permissions of restricted members,
+	// everybody else has the ones of the chat
+	if currentMember.Role != tb.Restricted {
+		rights, err := s.chatRights(msg.Chat)
+		if err != nil {
+			s.logger.Error("cannot get chat permissions", slog.String("error", err.Error()))
+			s.replyError(msg, errorMsg)
+			return err
 		}
+		currentMember.Rights = rights
+	}
+
+	active, err := s.activeRestrictions(msg.Chat.ID, action.Member.User.ID)
+	if err != nil {
+		s.logger.Error("cannot get restrictions in force", slog.String("error", err.Error()))
+		s.replyError(msg, errorMsg)
 		return err
 	}
 
+	restrictions := []appliedRestriction{{pollID: action.PollID, permission: permission, until: action.Until}}
+	for _, restriction := range active {
+		if restriction.pollID != action.PollID {
+			restrictions = append(restrictions, restriction)
+		}
+	}
+
 	currentMember.Independent = true
+	currentMember.RestrictedUntil = unixUntil(latestUntil(restrictions))
 
-	switch permission {
-	case "CanSendOther":
-		currentMember.CanSendOther = value
-	case "CanSendMedia":
-		currentMember.CanSendPhotos = value
-		currentMember.CanSendVideos = value
-		currentMember.CanSendDocuments = value
-		currentMember.CanSendAudios = value
-		currentMember.CanSendVoiceNotes = value
-		currentMember.CanSendVideoNotes = value
-	default:
-		return fmt.Errorf("unknown permission: %s", permission)
+	fields, err := permissionFields(&currentMember.Rights, permission)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		*field = false
+	}
+
+	return s.restrict(msg, currentMember, permission, false, errorMsg)
+}
+
+// ends the restriction the poll of the action applied once its term is over
+func (s *PermissionService) LiftPermission(action domain.PollAction, permission, errorMsg string) error {
+	return s.lift(action, permission, func(restriction appliedRestriction) bool {
+		return restriction.pollID == action.PollID
+	}, errorMsg)
+}
+
+// ends every restriction of the permission, like an unmute does with all the mutes
+func (s *PermissionService) AllowPermission(action domain.PollAction, permission, errorMsg string) error {
+	return s.lift(action, permission, func(restriction appliedRestriction) bool {
+		return restriction.permission == permission
+	}, errorMsg)
+}
+
+// gives the permission back as the chat has it by default. fields another
+// restriction still holds stay as they are, so a mute ending doesn't give back
+// the gifs a separate poll took. a member telegram already released at the
+// end of the term is left as is
+func (s *PermissionService) lift(action domain.PollAction, permission string, ended func(appliedRestriction) bool, errorMsg string) error {
+	msg := action.Message
+	currentMember, err := s.bot.ChatMemberOf(msg.Chat, action.Member.User)
+	if err != nil {
+		s.logger.Error("cannot get current member data", slog.String("error", err.Error()))
+		s.replyError(msg, "Чота не могу получить данные юзера")
+		return err
+	}
+
+	if currentMember.Role != tb.Restricted {
+		return nil
+	}
+
+	defaults, err := s.chatRights(msg.Chat)
+	if err != nil {
+		s.logger.Error("cannot get chat permissions", slog.String("error", err.Error()))
+		s.replyError(msg, errorMsg)
+		return err
+	}
+
+	active, err := s.activeRestrictions(msg.Chat.ID, action.Member.User.ID)
+	if err != nil {
+		s.logger.Error("cannot get restrictions in force", slog.String("error", err.Error()))
+		s.replyError(msg, errorMsg)
+		return err
+	}
+
+	var remaining []appliedRestriction
+	held := make(map[*bool]bool)
+	for _, restriction := range active {
+		if ended(restriction) {
+			continue
+		}
+		remaining = append(remaining, restriction)
+		heldFields, _ := permissionFields(&currentMember.Rights, restriction.permission)
+		for _, field := range heldFields {
+			held[field] = true
+		}
 	}
 
-	s.logger.Info("updating member permissions", 
+	// nothing the bot applied is left, the member goes back to the chat defaults
+	if len(remaining) == 0 {
+		currentMember.Rights = tb.NoRestrictions()
+		currentMember.RestrictedUntil = 0
+		return s.restrict(msg, currentMember, permission, true, errorMsg)
+	}
+
+	fields, err := permissionFields(&currentMember.Rights, permission)
+	if err != nil {
+		return err
+	}
+	defaultFields, _ := permissionFields(&defaults, permission)
+	for i, field := range fields {
+		if !held[field] {
+			*field = *defaultFields[i]
+		}
+	}
+	currentMember.Independent = true
+	currentMember.RestrictedUntil = unixUntil(latestUntil(remaining))
+
+	return s.restrict(msg, currentMember, permission, true, errorMsg)
+}
+
+func (s *PermissionService) restrict(msg *tb.Message, member *tb.ChatMember, permission string, value bool, errorMsg string) error {
+	s.logger.Info("updating member permissions",
 		slog.String("permission", permission),
 		slog.Bool("value", value),
-		slog.Int64("user_id", currentMember.User.ID))
+		slog.Int64("user_id", member.User.ID))
 
-	if err := s.bot.Restrict(msg.Chat, currentMember); err != nil {
-		s.logger.Error("cannot update permission", 
+	if err := s.bot.Restrict(msg.Chat, member); err != nil {
+		s.logger.Error("cannot update permission",
 			slog.String("permission", permission),
 			slog.Bool("value", value),
 			slog.String("error", err.Error()))
-		_, replyErr := s.bot.Reply(msg, errorMsg)
-		if replyErr != nil {
-			s.logger.Error("failed to send error message", slog.String("error", replyErr.Error()))
-		}
+		s.replyError(msg, errorMsg)
 		return err
 	}
+	return nil
+}
 
-	_, err = s.bot.Reply(msg, successMsg)
+// restrictions passed polls applied to the user that aren't over yet,
+// read from the history. an unmute ends every mute before it
+func (s *PermissionService) activeRestrictions(chatID, userID int64) ([]appliedRestriction, error) {
+	records, err := s.history.GetRecordsByUser(chatID, userID, restrictionHistoryLimit, 0)
 	if err != nil {
-		s.logger.Error("failed to reply to poll", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("failed to get poll history: %w", err)
+	}
+
+	now := time.Now()
+	unmuted := false
+	var active []appliedRestriction
+	for _, record := range records {
+		if record.Decision != domain.PollDecisionPassed || record.Error != "" {
+			continue
+		}
+		if record.Action == actionUnmute {
+			unmuted = true
+			continue
+		}
+
+		permission, ok := actionPermissions[record.Action]
+		if !ok || (unmuted && record.Action == actionMute) {
+			continue
+		}
+
+		restriction := appliedRestriction{pollID: record.PollID, permission: permission}
+		if record.ActionDuration > 0 {
+			restriction.until = record.FinishedAt.Add(record.ActionDuration)
+			if restriction.until.Before(now.Add(minRestriction)) {
+				continue
+			}
+		}
+		active = append(active, restriction)
+	}
+
+	return active, nil
+}
+
+// when the last of the restrictions ends, zero is forever and outlasts everything
+func latestUntil(restrictions []appliedRestriction) time.Time {
+	var latest time.Time
+	for i, restriction := range restrictions {
+		if restriction.until.IsZero() {
+			return time.Time{}
+		}
+		if i == 0 || restriction.until.After(latest) {
+			latest = restriction.until
+		}
+	}
+	return latest
+}
+
+// RestrictedUntil takes 0 for forever
+func unixUntil(until time.Time) int64 {
+	if until.IsZero() {
+		return 0
+	}
+	return until.Unix()
+}
+
+// default member permissions of the chat
+func (s *PermissionService) chatRights(chat *tb.Chat) (tb.Rights, error) {
+	fullChat, err := s.bot.ChatByID(chat.ID)
+	if err != nil {
+		return tb.Rights{}, fmt.Errorf("failed to get chat: %w", err)
+	}
+	if fullChat.Permissions == nil {
+		return tb.NoRestrictions(), nil
+	}
+	return *fullChat.Permissions, nil
+}
+
+func (s *PermissionService) replyError(msg *tb.Message, text string) {
+	if _, err := s.bot.Reply(msg, text, tb.AllowWithoutReply); err != nil {
+		s.logger.Error("failed to send error message", slog.String("error", err.Error()))
+	}
+}
+
+// fields of rights the permission covers
+func permissionFields(rights *tb.Rights, permission string) ([]*bool, error) {
+	media := []*bool{
+		&rights.CanSendPhotos,
+		&rights.CanSendVideos,
+		&rights.CanSendDocuments,
+		&rights.CanSendAudios,
+		&rights.CanSendVoiceNotes,
+		&rights.CanSendVideoNotes,
+	}
+
+	switch permission {
+	case "CanSendOther":
+		return []*bool{&rights.CanSendOther}, nil
+	case "CanSendMessages":
+		// muted for good, nothing of any kind can be sent
+		return append([]*bool{
+			&rights.CanSendMessages,
+			&rights.CanSendPolls,
+			&rights.CanSendOther,
+			&rights.CanAddPreviews,
+		}, media...), nil
+	case "CanSendMedia":
+		return media, nil
+	default:
+		return nil, fmt.Errorf("unknown permission: %s", permission)
 	}
-	return err
 }
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+const (
+	testChatID = -100
+	testUserID = 42
+)
+
+func passedRecord(pollID, action string, finished time.Time, duration time.Duration) *domain.PollRecord {
+	return &domain.PollRecord{
+		PollID:         pollID,
+		ChatID:         testChatID,
+		UserID:         testUserID,
+		FinishedAt:     finished,
+		ActionDuration: duration,
+		Decision:       domain.PollDecisionPassed,
+		Action:         action,
+	}
+}
+
+func testAction(pollID string) domain.PollAction {
+	user := &tb.User{ID: testUserID}
+	return domain.PollAction{
+		PollID:  pollID,
+		Message: &tb.Message{ID: 1, Chat: &tb.Chat{ID: testChatID}},
+		Member:  &tb.ChatMember{User: user},
+	}
+}
+
+// muted member who also lost gifs with a poll of its own
+func mutedWithoutGifs(t *testing.T) (*fakeAPI, *memoryHistory, *PermissionService) {
+	t.Helper()
+
+	// the chat itself doesn't allow polls
+	defaults := tb.NoRestrictions()
+	defaults.CanSendPolls = false
+
+	api := &fakeAPI{
+		member:     &tb.ChatMember{User: &tb.User{ID: testUserID}, Role: tb.Restricted},
+		chatRights: &defaults,
+	}
+	history := &memoryHistory{}
+	history.AddRecord(passedRecord("gif", actionRestrictGifs, time.Now().Add(-time.Hour), 0))
+	history.AddRecord(passedRecord("mute", actionMute, time.Now().Add(-time.Minute), time.Hour))
+
+	return api, history, NewPermissionService(api, slog.New(slog.DiscardHandler), history)
+}
+
+func TestUnmuteKeepsOtherRestrictions(t *testing.T) {
+	api, _, perms := mutedWithoutGifs(t)
+
+	if err := perms.AllowPermission(testAction("unmute"), "CanSendMessages", "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	rights := api.lastRestriction().Rights
+	if !rights.CanSendMessages || !rights.CanSendPhotos {
+		t.Errorf("messages and media not given back: %+v", rights)
+	}
+	if rights.CanSendPolls {
+		t.Error("polls given back although the chat doesn't allow them")
+	}
+	if rights.CanSendOther {
+		t.Error("gifs given back although their own restriction isn't over")
+	}
+}
+
+func TestLiftEndsOnlyItsOwnRestriction(t *testing.T) {
+	api, history, perms := mutedWithoutGifs(t)
+	// a second mute for longer than the one being lifted
+	history.AddRecord(passedRecord("long mute", actionMute, time.Now(), 24*time.Hour))
+
+	if err := perms.LiftPermission(testAction("mute"), "CanSendMessages", "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rights := api.lastRestriction().Rights; rights.CanSendMessages {
+		t.Errorf("the longer mute was lifted too: %+v", rights)
+	}
+}
+
+func TestUnmuteEndsEveryMute(t *testing.T) {
+	api, history, perms := mutedWithoutGifs(t)
+	history.AddRecord(passedRecord("long mute", actionMute, time.Now(), 24*time.Hour))
+
+	if err := perms.AllowPermission(testAction("unmute"), "CanSendMessages", "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rights := api.lastRestriction().Rights; !rights.CanSendMessages {
+		t.Errorf("still muted after unmute: %+v", rights)
+	}
+}
+
+func TestUnmuteOfReleasedMemberIsNoop(t *testing.T) {
+	api, _, perms := mutedWithoutGifs(t)
+	api.member.Role = tb.Member
+
+	if err := perms.AllowPermission(testAction("unmute"), "CanSendMessages", "error"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(api.restricted) != 0 {
+		t.Errorf("restricted a member telegram already released: %+v", api.restricted)
+	}
+}
+
+func TestRestrictUntil(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		until  time.Time
+		others []*domain.PollRecord
+		want   time.Time
+	}{
+		{"forever", time.Time{}, nil, time.Time{}},
+		{"for a while", now.Add(time.Hour), nil, now.Add(time.Hour)},
+		{
+			"forever while muted for a while",
+			time.Time{},
+			[]*domain.PollRecord{passedRecord("mute", actionMute, now, time.Hour)},
+			time.Time{},
+		},
+		{
+			"for a while while muted for longer",
+			now.Add(time.Hour),
+			[]*domain.PollRecord{passedRecord("mute", actionMute, now, 24*time.Hour)},
+			now.Add(24 * time.Hour),
+		},
+		{
+			"for a while while muted forever",
+			now.Add(time.Hour),
+			[]*domain.PollRecord{passedRecord("mute", actionMute, now, 0)},
+			time.Time{},
+		},
+		{
+			"longer than the mute",
+			now.Add(24 * time.Hour),
+			[]*domain.PollRecord{passedRecord("mute", actionMute, now, time.Hour)},
+			now.Add(24 * time.Hour),
+		},
+		{
+			"mute already over",
+			now.Add(time.Hour),
+			[]*domain.PollRecord{passedRecord("mute", actionMute, now.Add(-2*time.Hour), time.Hour)},
+			now.Add(time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{member: &tb.ChatMember{User: &tb.User{ID: testUserID}, Role: tb.Member}}
+			history := &memoryHistory{}
+			for _, record := range tt.others {
+				history.AddRecord(record)
+			}
+			perms := NewPermissionService(api, slog.New(slog.DiscardHandler), history)
+
+			action := testAction("gif")
+			action.Until = tt.until
+			if err := perms.RestrictPermission(action, "CanSendOther", "error"); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, want := api.lastRestriction().RestrictedUntil, unixUntil(tt.want); got != want {
+				t.Errorf("RestrictedUntil = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLiftUntil(t *testing.T) {
+	now := time.Now()
+
+	t.Run("last restriction ends", func(t *testing.T) {
+		api := &fakeAPI{member: &tb.ChatMember{User: &tb.User{ID: testUserID}, Role: tb.Restricted}}
+		history := &memoryHistory{}
+		history.AddRecord(passedRecord("gif", actionRestrictGifs, now.Add(-time.Hour), time.Hour))
+		perms := NewPermissionService(api, slog.New(slog.DiscardHandler), history)
+
+		if err := perms.LiftPermission(testAction("gif"), "CanSendOther", "error"); err != nil {
+			t.Fatal(err)
+		}
+
+		got := api.lastRestriction()
+		if got.Rights != tb.NoRestrictions() || got.RestrictedUntil != 0 {
+			t.Errorf("not unrestricted: %+v", got)
+		}
+	})
+
+	t.Run("another restriction is left", func(t *testing.T) {
+		api := &fakeAPI{member: &tb.ChatMember{User: &tb.User{ID: testUserID}, Role: tb.Restricted}}
+		history := &memoryHistory{}
+		history.AddRecord(passedRecord("media", actionRestrictMedia, now, 3*time.Hour))
+		history.AddRecord(passedRecord("gif", actionRestrictGifs, now.Add(-time.Hour), time.Hour))
+		perms := NewPermissionService(api, slog.New(slog.DiscardHandler), history)
+
+		if err := perms.LiftPermission(testAction("gif"), "CanSendOther", "error"); err != nil {
+			t.Fatal(err)
+		}
+
+		got := api.lastRestriction()
+		if want := now.Add(3 * time.Hour).Unix(); got.RestrictedUntil != want {
+			t.Errorf("RestrictedUntil = %d, want the end of the media restriction %d", got.RestrictedUntil, want)
+		}
+		if !got.Rights.CanSendOther || got.Rights.CanSendPhotos {
+			t.Errorf("rights = %+v, want gifs back and media still taken", got.Rights)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
+)
+
+// how many history records of the target are looked through for the last poll of the type
+const cooldownLookback = 20
+
+type guardTarget struct {
+	chatID   int64
+	targetID int64
+	pollType domain.PollType
+}
+
+type guardInitiator struct {
+	chatID      int64
+	initiatorID int64
+}
+
+// implements PollGuard. polls being created are tracked in memory, so two
+// commands at once can't both get past the active poll check
+type PollGuardService struct {
+	logger   *slog.Logger
+	polls    domain.PollStorage
+	history  domain.PollHistory
+	config   domain.ConfigProvider
+	cooldown time.Duration
+	// polls an initiator may start per window, 0 disables the limit
+	initiatorLimit  int
+	initiatorWindow time.Duration
+
+	mutex    sync.Mutex
+	creating map[guardTarget]time.Time // when the creation began
+	started  map[guardInitiator][]time.Time
+}
+
+func NewPollGuardService(polls domain.PollStorage, history domain.PollHistory, config domain.ConfigProvider, logger *slog.Logger) *PollGuardService {
+	service := &PollGuardService{
+		logger:          logger,
+		polls:           polls,
+		history:         history,
+		config:          config,
+		cooldown:        30 * time.Minute, // def
+		initiatorLimit:  3,
+		initiatorWindow: time.Hour,
+		creating:        make(map[guardTarget]time.Time),
+		started:         make(map[guardInitiator][]time.Time),
+	}
+
+	// conf
+	if cooldownStr := os.Getenv("VOTEBAN_TARGET_COOLDOWN"); cooldownStr != "" {
+		if cooldown, err := time.ParseDuration(cooldownStr); err == nil && cooldown >= 0 {
+			service.cooldown = cooldown
+		} else {
+			logger.Warn("invalid VOTEBAN_TARGET_COOLDOWN in environment", slog.String("value", cooldownStr))
+		}
+	}
+
+	if limitStr := os.Getenv("VOTEBAN_INITIATOR_LIMIT"); limitStr != "" {
+		if limit, err := strconv.Atoi(limitStr); err == nil && limit >= 0 {
+			service.initiatorLimit = limit
+		} else {
+			logger.Warn("invalid VOTEBAN_INITIATOR_LIMIT in environment", slog.String("value", limitStr))
+		}
+	}
+
+	if windowStr := os.Getenv("VOTEBAN_INITIATOR_WINDOW"); windowStr != "" {
+		if window, err := time.ParseDuration(windowStr); err == nil && window > 0 {
+			service.initiatorWindow = window
+		} else {
+			logger.Warn("invalid VOTEBAN_INITIATOR_WINDOW in environment", slog.String("value", windowStr))
+		}
+	}
+
+	return service
+}
+
+func (s *PollGuardService) Acquire(chatID, targetID, initiatorID int64, pollType domain.PollType) (func(created bool), error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	target := guardTarget{chatID: chatID, targetID: targetID, pollType: pollType}
+	initiator := guardInitiator{chatID: chatID, initiatorID: initiatorID}
+
+	// the poll being created runs for the poll duration of the chat once it is sent
+	if began, ok := s.creating[target]; ok {
+		wait := began.Add(s.config.Settings(chatID).PollDuration).Sub(now)
+		return nil, limitError("такое голосование уже создаётся, до конца %s", max(wait, time.Minute))
+	}
+
+	if wait, err := s.activePollWait(target, now); err != nil {
+		return nil, err
+	} else if wait > 0 {
+		return nil, limitError("такое голосование уже идёт, до конца %s", wait)
+	}
+
+	if wait, err := s.cooldownWait(target, now); err != nil {
+		return nil, err
+	} else if wait > 0 {
+		return nil, limitError("такое голосование недавно было, следующее можно через %s", wait)
+	}
+
+	if wait := s.initiatorWait(initiator, now); wait > 0 {
+		return nil, limitError("слишком часто начинаешь голосования, следующее можно через %s", wait)
+	}
+
+	s.creating[target] = now
+
+	return func(created bool) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		delete(s.creating, target)
+		if created && s.initiatorLimit > 0 {
+			s.started[initiator] = append(s.started[initiator], time.Now())
+		}
+	}, nil
+}
+
+// time until the running poll against the target ends, zero without one.
+// polls waiting to lift their action are over already and go by the cooldown,
+// dead-lettered ones wait for an admin and don't block new polls
+func (s *PollGuardService) activePollWait(target guardTarget, now time.Time) (time.Duration, error) {
+	polls, err := s.polls.GetPollsByType(target.pollType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get active polls: %w", err)
+	}
+
+	for _, poll := range polls {
+		if poll.ChatID != target.chatID || poll.UserID != target.targetID {
+			continue
+		}
+		if poll.DeadLetter || poll.CurrentState() == domain.PollStateLifting {
+			continue
+		}
+		// a poll past its expiry is still being processed
+		return max(poll.ExpiresAt.Sub(now), time.Minute), nil
+	}
+
+	return 0, nil
+}
+
+// time left of the cooldown after the last poll of the type against the target
+func (s *PollGuardService) cooldownWait(target guardTarget, now time.Time) (time.Duration, error) {
+	if s.cooldown == 0 {
+		return 0, nil
+	}
+
+	records, err := s.history.GetRecordsByUser(target.chatID, target.targetID, cooldownLookback, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get poll history: %w", err)
+	}
+
+	for _, record := range records {
+		if record.Type == target.pollType {
+			return record.FinishedAt.Add(s.cooldown).Sub(now), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// time until the initiator may start another poll, callers hold the mutex
+func (s *PollGuardService) initiatorWait(initiator guardInitiator, now time.Time) time.Duration {
+	if s.initiatorLimit == 0 {
+		return 0
+	}
+
+	started := s.started[initiator]
+	for len(started) > 0 && now.Sub(started[0]) >= s.initiatorWindow {
+		started = started[1:]
+	}
+
+	if len(started) == 0 {
+		delete(s.started, initiator)
+		return 0
+	}
+	s.started[initiator] = started
+
+	if len(started) < s.initiatorLimit {
+		return 0
+	}
+	return started[len(started)-s.initiatorLimit].Add(s.initiatorWindow).Sub(now)
+}
+
+// format takes the wait as its only argument
+func limitError(format string, wait time.Duration) *domain.PollLimitError {
+	return &domain.PollLimitError{Message: fmt.Sprintf(format, utils.FormatWait(wait)), Wait: wait}
+}
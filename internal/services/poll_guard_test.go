@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
+)
+
+const pollDuration = 10 * time.Minute
+
+// poll guard with the storage behind it
+type testGuard struct {
+	*PollGuardService
+	t       *testing.T
+	polls   domain.PollStorage
+	history *memoryHistory
+}
+
+func newTestGuard(t *testing.T) *testGuard {
+	t.Helper()
+
+	// the defaults: 30m cooldown, 3 polls an hour per initiator
+	for _, env := range []string{"VOTEBAN_TARGET_COOLDOWN", "VOTEBAN_INITIATOR_LIMIT", "VOTEBAN_INITIATOR_WINDOW"} {
+		t.Setenv(env, "")
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	polls, err := utils.NewFilePollStorage(filepath.Join(t.TempDir(), "active_polls.json"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := &memoryHistory{}
+	config := staticConfig{settings: domain.ChatSettings{PollDuration: pollDuration}}
+
+	return &testGuard{
+		PollGuardService: NewPollGuardService(polls, history, config, logger),
+		t:                t,
+		polls:            polls,
+		history:          history,
+	}
+}
+
+// acquires the poll, failing the test if it isn't allowed
+func (g *testGuard) allow(chatID, targetID, initiatorID int64, pollType domain.PollType) func(created bool) {
+	g.t.Helper()
+
+	release, err := g.Acquire(chatID, targetID, initiatorID, pollType)
+	if err != nil {
+		g.t.Fatalf("got %v, want the poll allowed", err)
+	}
+	return release
+}
+
+// the wait of the limit error the poll is refused with
+func (g *testGuard) refuse(chatID, targetID, initiatorID int64, pollType domain.PollType) time.Duration {
+	g.t.Helper()
+
+	release, err := g.Acquire(chatID, targetID, initiatorID, pollType)
+	var limitErr *domain.PollLimitError
+	if !errors.As(err, &limitErr) {
+		if release != nil {
+			release(false)
+		}
+		g.t.Fatalf("got %v, want a poll limit error", err)
+	}
+	return limitErr.Wait
+}
+
+func within(wait, want time.Duration) bool {
+	return wait > want-time.Minute && wait <= want
+}
+
+func TestPollGuardBeingCreated(t *testing.T) {
+	guard := newTestGuard(t)
+
+	release := guard.allow(testChatID, testUserID, 1, domain.PollTypeBan)
+
+	if wait := guard.refuse(testChatID, testUserID, 2, domain.PollTypeBan); !within(wait, pollDuration) {
+		t.Errorf("poll being created waits %v, want about %v", wait, pollDuration)
+	}
+
+	// another type or target is a poll of its own
+	guard.allow(testChatID, testUserID, 2, domain.PollTypeKick)(false)
+	guard.allow(testChatID, testUserID+1, 2, domain.PollTypeBan)(false)
+
+	// a poll that failed to be created frees the target
+	release(false)
+	guard.allow(testChatID, testUserID, 2, domain.PollTypeBan)(false)
+}
+
+func TestPollGuardActivePoll(t *testing.T) {
+	guard := newTestGuard(t)
+
+	save := func(poll *domain.ActivePoll) {
+		poll.Type = domain.PollTypeBan
+		poll.ChatID = testChatID
+		poll.UserID = testUserID
+		if err := guard.polls.SavePoll(poll); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save(&domain.ActivePoll{ID: "failed", State: domain.PollStateActing, DeadLetter: true})
+	save(&domain.ActivePoll{ID: "lifting", State: domain.PollStateLifting, LiftAt: time.Now().Add(time.Hour)})
+	guard.allow(testChatID, testUserID, 1, domain.PollTypeBan)(false)
+
+	save(&domain.ActivePoll{ID: "running", State: domain.PollStatePending, ExpiresAt: time.Now().Add(20 * time.Minute)})
+	if wait := guard.refuse(testChatID, testUserID, 1, domain.PollTypeBan); !within(wait, 20*time.Minute) {
+		t.Errorf("running poll waits %v, want about 20m", wait)
+	}
+}
+
+func TestPollGuardCooldown(t *testing.T) {
+	guard := newTestGuard(t)
+
+	guard.history.AddRecord(&domain.PollRecord{
+		ChatID:     testChatID,
+		UserID:     testUserID,
+		Type:       domain.PollTypeBan,
+		FinishedAt: time.Now().Add(-10 * time.Minute),
+	})
+
+	if wait := guard.refuse(testChatID, testUserID, 1, domain.PollTypeBan); !within(wait, 20*time.Minute) {
+		t.Errorf("cooldown waits %v, want about 20m", wait)
+	}
+	guard.allow(testChatID, testUserID, 1, domain.PollTypeKick)(false)
+
+	guard.history.AddRecord(&domain.PollRecord{
+		ChatID:     testChatID,
+		UserID:     testUserID + 1,
+		Type:       domain.PollTypeBan,
+		FinishedAt: time.Now().Add(-time.Hour),
+	})
+	guard.allow(testChatID, testUserID+1, 1, domain.PollTypeBan)(false)
+}
+
+func TestPollGuardInitiatorLimit(t *testing.T) {
+	guard := newTestGuard(t)
+
+	// polls that weren't created don't count
+	guard.allow(testChatID, 100, 1, domain.PollTypeBan)(false)
+
+	for target := range int64(3) {
+		guard.allow(testChatID, 200+target, 1, domain.PollTypeBan)(true)
+	}
+
+	if wait := guard.refuse(testChatID, 300, 1, domain.PollTypeBan); !within(wait, time.Hour) {
+		t.Errorf("initiator waits %v, want about 1h", wait)
+	}
+
+	// the limit is per initiator and chat
+	guard.allow(testChatID, 300, 2, domain.PollTypeBan)(false)
+	guard.allow(testChatID-1, 300, 1, domain.PollTypeBan)(false)
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+// actions recorded in the poll history
+const (
+	actionNone          = "none"
+	actionBan           = "ban"
+	actionUnban         = "unban"
+	actionKick          = "kick"
+	actionRestrictGifs  = "restrict_gifs"
+	actionRestrictMedia = "restrict_media"
+	actionMute          = "mute"
+	actionUnmute        = "unmute"
+)
+
+// every poll type the bot knows, a new one only has to be added here
+func NewPollKinds(bot tb.API, logger *slog.Logger, perms *PermissionService) *domain.PollKinds {
+	ban := &banKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypeBan,
+			commands: []string{"ban", "voteban", "vote"},
+			help:     "Start vote to ban user, forever or for the given time",
+			name:     "бан",
+			question: "Банить?",
+			options:  []string{"Да", "Нет"},
+			duration: true,
+		},
+		bot:    bot,
+		logger: logger,
+	}
+	unban := &unbanKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypeUnban,
+			commands: []string{"unban", "voteunban"},
+			help:     "Start vote to unban user",
+			name:     "разбан",
+			question: "Разбанить?",
+			options:  []string{"Да", "Нет"},
+		},
+		bot:    bot,
+		logger: logger,
+	}
+	kick := &kickKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypeKick,
+			commands: []string{"kick", "votekick"},
+			help:     "Start vote to kick user, they can join again",
+			name:     "кик",
+			question: "Кикнуть?",
+			options:  []string{"Да", "Нет"},
+		},
+		bot:    bot,
+		logger: logger,
+	}
+	gifs := &restrictKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypeGifs,
+			commands: []string{"gif", "votegif"},
+			help:     "Start vote to restrict gifs/stickers",
+			name:     "стикеры/гифки",
+			question: "Стикеры/гифки этому челу:",
+			options:  []string{"Запретить", "Разрешить"},
+			duration: true,
+		},
+		perms:      perms,
+		permission: "CanSendOther",
+		action:     actionRestrictGifs,
+		errorMsg:   "Чота не могу отключить стикеры",
+		successMsg: "-брейнрот",
+		liftError:  "Чота не могу вернуть стикеры",
+		liftMsg:    "%s снова можно стикеры и гифки",
+	}
+	media := &restrictKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypeMedia,
+			commands: []string{"media", "votemedia"},
+			help:     "Start vote to restrict media",
+			name:     "медиа",
+			question: "Медиа этому челу:",
+			options:  []string{"Запретить", "Разрешить"},
+			duration: true,
+		},
+		perms:      perms,
+		permission: "CanSendMedia",
+		action:     actionRestrictMedia,
+		errorMsg:   "Чота не могу отключить медиа",
+		successMsg: "Медиа заблокированы",
+		liftError:  "Чота не могу вернуть медиа",
+		liftMsg:    "%s снова можно медиа",
+	}
+	mute := &restrictKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypeMute,
+			commands: []string{"mute", "votemute"},
+			help:     "Start vote to mute user completely",
+			name:     "мут",
+			question: "Замутить?",
+			options:  []string{"Да", "Нет"},
+			duration: true,
+		},
+		perms:      perms,
+		permission: "CanSendMessages",
+		action:     actionMute,
+		errorMsg:   "Чота не могу замутить",
+		successMsg: "Замучен",
+		liftError:  "Чота не могу размутить",
+		liftMsg:    "Мут %s закончился",
+	}
+	unmute := &restrictKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypeUnmute,
+			commands: []string{"unmute", "voteunmute"},
+			help:     "Start vote to unmute user",
+			name:     "размут",
+			question: "Размутить?",
+			options:  []string{"Да", "Нет"},
+		},
+		perms:      perms,
+		permission: "CanSendMessages",
+		allow:      true,
+		action:     actionUnmute,
+		errorMsg:   "Чота не могу размутить",
+		successMsg: "Размучен",
+	}
+
+	return domain.NewPollKinds(ban, unban, kick, gifs, media, mute, unmute,
+		newPunishKind(logger, mute, kick, ban))
+}
+
+// the descriptive part of a poll kind, shared by all of them
+type pollKindInfo struct {
+	pollType domain.PollType
+	commands []string
+	help     string
+	name     string
+	question string
+	options  []string
+	duration bool
+}
+
+func (k *pollKindInfo) Type() domain.PollType { return k.pollType }
+func (k *pollKindInfo) Commands() []string    { return k.commands }
+func (k *pollKindInfo) Help() string          { return k.help }
+func (k *pollKindInfo) Name() string          { return k.name }
+func (k *pollKindInfo) Question() string      { return k.question }
+func (k *pollKindInfo) Options() []string     { return k.options }
+func (k *pollKindInfo) TakesDuration() bool   { return k.duration }
+
+func (k *pollKindInfo) Validate(target *tb.ChatMember) error {
+	return nil
+}
+
+// only temporary actions are lifted, kinds without a duration never are
+func (k *pollKindInfo) Lift(action domain.PollAction) (string, error) {
+	return "", nil
+}
+
+type banKind struct {
+	pollKindInfo
+	bot    tb.API
+	logger *slog.Logger
+}
+
+// zero until bans forever
+func (k *banKind) Apply(action domain.PollAction) (domain.PollActionResult, error) {
+	target := *action.Member
+	target.RestrictedUntil = 0
+	if !action.Until.IsZero() {
+		target.RestrictedUntil = action.Until.Unix()
+	}
+
+	if err := k.bot.Ban(action.Message.Chat, &target); err != nil {
+		k.logger.Error("cannot ban user", slog.String("error", err.Error()))
+		_, replyErr := k.bot.Reply(action.Message, "Чота не могу забанить")
+		if replyErr != nil {
+			k.logger.Error("failed to send error message", slog.String("error", replyErr.Error()))
+		}
+		return domain.PollActionResult{Action: actionBan}, err
+	}
+
+	return domain.PollActionResult{
+		Action:       actionBan,
+		Announcement: "BAN B AN BAN BAN BANBANBANBAN BAN BANBANBA NB ANBANB ANBANB ANBANB ANBAN BAN BANBA NBNBANBANB AN BA NBA NBANBA NB ANB ANB AN BANB AN\n!!!!!!!!\n!!!!!!!!!!!!!!!!!!!!\n!!!!!!!!!!!!!!!!!\n!!!!!!!!!!!!!!!!!!\n!!!!!!!!\n!!!!!!!!!!!!\n\n" + action.Summary,
+	}, nil
+}
+
+func (k *banKind) Lift(action domain.PollAction) (string, error) {
+	// no-op when telegram already unbanned them at the end of the term
+	if err := k.bot.Unban(action.Message.Chat, action.Member.User, true); err != nil {
+		return "", fmt.Errorf("failed to unban user: %w", err)
+	}
+	return fmt.Sprintf("Бан %s закончился", memberName(action.Member)), nil
+}
+
+type unbanKind struct {
+	pollKindInfo
+	bot    tb.API
+	logger *slog.Logger
+}
+
+func (k *unbanKind) Apply(action domain.PollAction) (domain.PollActionResult, error) {
+	if err := k.bot.Unban(action.Message.Chat, action.Member.User, true); err != nil {
+		k.logger.Error("cannot unban user", slog.String("error", err.Error()))
+		_, replyErr := k.bot.Reply(action.Message, "Чота не могу разбанить")
+		if replyErr != nil {
+			k.logger.Error("failed to send error message", slog.String("error", replyErr.Error()))
+		}
+		return domain.PollActionResult{Action: actionUnban}, err
+	}
+
+	return domain.PollActionResult{Action: actionUnban, Announcement: "Разбанен\n\n" + action.Summary}, nil
+}
+
+type kickKind struct {
+	pollKindInfo
+	bot    tb.API
+	logger *slog.Logger
+}
+
+// bans and unbans right away, so the user is out but can join again
+func (k *kickKind) Apply(action domain.PollAction) (domain.PollActionResult, error) {
+	target := *action.Member
+	target.RestrictedUntil = 0
+
+	if err := k.bot.Ban(action.Message.Chat, &target); err != nil {
+		k.logger.Error("cannot kick user", slog.String("error", err.Error()))
+		_, replyErr := k.bot.Reply(action.Message, "Чота не могу кикнуть")
+		if replyErr != nil {
+			k.logger.Error("failed to send error message", slog.String("error", replyErr.Error()))
+		}
+		return domain.PollActionResult{Action: actionKick}, err
+	}
+
+	// left as is the kick would be a permanent ban, the retry kicks again
+	if err := k.bot.Unban(action.Message.Chat, action.Member.User, true); err != nil {
+		k.logger.Error("cannot unban kicked user", slog.String("error", err.Error()))
+		return domain.PollActionResult{Action: actionKick}, err
+	}
+
+	return domain.PollActionResult{Action: actionKick, Announcement: "Кикнут, но может вернуться\n\n" + action.Summary}, nil
+}
+
+// takes a permission away, or gives back what the chat allows when allow is set
+type restrictKind struct {
+	pollKindInfo
+	perms      *PermissionService
+	permission string
+	allow      bool
+	action     string
+	errorMsg   string
+	successMsg string
+	liftError  string
+	// formatted with the member name
+	liftMsg string
+}
+
+func (k *restrictKind) Apply(action domain.PollAction) (domain.PollActionResult, error) {
+	var err error
+	if k.allow {
+		err = k.perms.AllowPermission(action, k.permission, k.errorMsg)
+	} else {
+		err = k.perms.RestrictPermission(action, k.permission, k.errorMsg)
+	}
+	if err != nil {
+		return domain.PollActionResult{Action: k.action}, err
+	}
+	return domain.PollActionResult{Action: k.action, Announcement: k.successMsg + "\n\n" + action.Summary}, nil
+}
+
+func (k *restrictKind) Lift(action domain.PollAction) (string, error) {
+	if err := k.perms.LiftPermission(action, k.permission, k.liftError); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(k.liftMsg, memberName(action.Member)), nil
+}
@@ -2,7 +2,12 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/uaru-shit/votes/internal/domain"
@@ -11,19 +16,57 @@ import (
 )
 
 type PollMonitorService struct {
-	bot         tb.API
-	logger      *slog.Logger
-	pollStorage domain.PollStorage
-	processor   *PollProcessorService
+	bot            tb.API
+	logger         *slog.Logger
+	pollStorage    domain.PollStorage
+	pollHistory    domain.PollHistory
+	processor      *PollProcessorService
+	quorum         *QuorumService
+	eligibility    *EligibilityService
+	scheduler      *Scheduler
+	retry          RetryPolicy
+	earlyCloseLead int
+
+	mutex   sync.Mutex
+	tracked map[string]*trackedPoll
+	// polls taken out of tracked by their job and being processed, by poll id
+	closing map[string]bool
+}
+
+// monitored poll with its running tally
+type trackedPoll struct {
+	poll *domain.ActivePoll
+	// raw tally from the last poll update
+	options []domain.PollOptionResult
+	// chat member count, fetched on the first poll update
+	members int
 }
 
-func NewPollMonitorService(bot tb.API, logger *slog.Logger, pollStorage domain.PollStorage, processor *PollProcessorService) *PollMonitorService {
-	return &PollMonitorService{
+func NewPollMonitorService(bot tb.API, logger *slog.Logger, pollStorage domain.PollStorage, pollHistory domain.PollHistory, processor *PollProcessorService, quorum *QuorumService, eligibility *EligibilityService, scheduler *Scheduler, retry RetryPolicy) *PollMonitorService {
+	service := &PollMonitorService{
 		bot:         bot,
 		logger:      logger,
 		pollStorage: pollStorage,
+		pollHistory: pollHistory,
 		processor:   processor,
+		quorum:      quorum,
+		eligibility: eligibility,
+		scheduler:   scheduler,
+		retry:       retry,
+		tracked:     make(map[string]*trackedPoll),
+		closing:     make(map[string]bool),
+	}
+
+	// conf
+	if leadStr := os.Getenv("VOTEBAN_EARLY_CLOSE_LEAD"); leadStr != "" {
+		if lead, err := strconv.Atoi(leadStr); err == nil && lead >= 0 {
+			service.earlyCloseLead = lead
+		} else {
+			logger.Warn("invalid VOTEBAN_EARLY_CLOSE_LEAD in environment", slog.String("value", leadStr))
+		}
 	}
+
+	return service
 }
 
 func (s *PollMonitorService) RestoreActivePolls() {
@@ -36,66 +79,485 @@ func (s *PollMonitorService) RestoreActivePolls() {
 	s.logger.Info("restoring active polls", slog.Int("count", len(polls)))
 
 	for _, poll := range polls {
-		go s.monitorPoll(context.Background(), poll)
+		if poll.DeadLetter {
+			s.logger.Warn("skipping dead-lettered poll",
+				slog.String("poll_id", poll.ID),
+				slog.Int("attempts", poll.Attempts),
+				slog.String("last_error", poll.LastError))
+			continue
+		}
+		s.StartPollMonitoring(poll)
 	}
 }
 
+// tracks the answers of a poll still open in telegram and schedules it
 func (s *PollMonitorService) StartPollMonitoring(poll *domain.ActivePoll) {
-	go s.monitorPoll(context.Background(), poll)
+	state := poll.CurrentState()
+
+	// the telegram poll may still be open while it is being stopped
+	if state == domain.PollStatePending || state == domain.PollStateStopping {
+		s.mutex.Lock()
+		s.tracked[poll.ID] = &trackedPoll{poll: poll}
+		s.mutex.Unlock()
+	}
+
+	s.scheduleMonitored(poll)
 }
 
-func (s *PollMonitorService) monitorPoll(ctx context.Context, poll *domain.ActivePoll) {
-	s.logger.Info("starting poll monitoring", 
+// schedules the poll to be processed when it expires, or right away
+// if it already expired, was closed early or its processing was interrupted by a restart
+func (s *PollMonitorService) scheduleMonitored(poll *domain.ActivePoll) {
+	state := poll.CurrentState()
+	at := poll.ExpiresAt
+	switch {
+	case state == domain.PollStateLifting:
+		at = poll.LiftAt
+	case poll.ClosedEarly || state != domain.PollStatePending:
+		at = time.Now()
+	}
+
+	s.logger.Info("scheduling poll",
 		slog.String("poll_id", poll.ID),
 		slog.String("type", string(poll.Type)),
-		slog.Time("expires_at", poll.ExpiresAt))
-	
-	timeUntilExpiration := time.Until(poll.ExpiresAt)
-	if timeUntilExpiration <= 0 {
-		s.logger.Info("poll already expired, processing immediately", 
-			slog.String("poll_id", poll.ID))
+		slog.String("state", string(state)),
+		slog.Time("expires_at", poll.ExpiresAt),
+		slog.Time("process_at", at))
+
+	s.schedule(poll, at)
+}
+
+func (s *PollMonitorService) schedule(poll *domain.ActivePoll, at time.Time) {
+	s.scheduler.Add(poll.ID, at, func(ctx context.Context) {
+		s.mutex.Lock()
+		if entry, ok := s.tracked[poll.ID]; ok && entry.options != nil {
+			poll.LastTally = entry.options
+		}
+		delete(s.tracked, poll.ID)
+		s.closing[poll.ID] = true
+		s.mutex.Unlock()
+
+		s.logger.Info("poll expired, processing", slog.String("poll_id", poll.ID))
 		s.processPoll(poll)
+
+		s.mutex.Lock()
+		delete(s.closing, poll.ID)
+		s.mutex.Unlock()
+	})
+}
+
+// keeps the vote ledger of a monitored poll in sync with poll answers,
+// an answer without options means the vote was retracted
+func (s *PollMonitorService) HandlePollAnswer(answer *tb.PollAnswer) {
+	if answer == nil {
 		return
 	}
 
-	s.logger.Info("waiting for poll to expire", 
+	// votes on behalf of a channel or anonymous admin have no user to attribute
+	if answer.Sender == nil {
+		s.logger.Debug("ignoring poll answer without user", slog.String("telegram_poll_id", answer.PollID))
+		return
+	}
+
+	s.mutex.Lock()
+	entry := s.findTracked(answer.PollID)
+	if entry == nil {
+		s.mutex.Unlock()
+		s.handleUntrackedAnswer(answer)
+		return
+	}
+	poll := entry.poll
+
+	if len(answer.Options) == 0 {
+		poll.RetractVote(answer.Sender.ID)
+	} else {
+		poll.RecordVote(domain.Vote{
+			VoterID:    answer.Sender.ID,
+			VoterIsBot: answer.Sender.IsBot,
+			Option:     answer.Options[0],
+			VotedAt:    time.Now(),
+		})
+	}
+
+	s.logger.Debug("poll vote recorded",
 		slog.String("poll_id", poll.ID),
-		slog.String("duration", timeUntilExpiration.String()))
-	
-	timer := time.NewTimer(timeUntilExpiration)
-	defer timer.Stop()
+		slog.Int64("voter_id", answer.Sender.ID),
+		slog.Any("options", answer.Options))
 
-	select {
-	case <-timer.C:
-		s.logger.Info("poll expired, processing", slog.String("poll_id", poll.ID))
-		s.processPoll(poll)
-	case <-ctx.Done():
-		s.logger.Info("poll monitoring cancelled", slog.String("poll_id", poll.ID))
+	// saved under the lock so the ledger isn't changed mid-write
+	if err := s.pollStorage.SavePoll(poll); err != nil {
+		s.logger.Error("failed to save poll votes",
+			slog.String("poll_id", poll.ID),
+			slog.String("error", err.Error()))
+	}
+	s.mutex.Unlock()
+
+	s.checkEarlyClose(entry)
+}
+
+// an answer for a poll the monitor doesn't hold. a poll still open in storage
+// was never restored, so it is picked up with the vote instead of losing it
+func (s *PollMonitorService) handleUntrackedAnswer(answer *tb.PollAnswer) {
+	polls, err := s.pollStorage.GetPolls()
+	if err != nil {
+		s.logger.Error("failed to look up poll of answer",
+			slog.String("telegram_poll_id", answer.PollID),
+			slog.String("error", err.Error()))
 		return
 	}
+
+	var poll *domain.ActivePoll
+	for _, stored := range polls {
+		if stored.TelegramPollID == answer.PollID {
+			poll = stored
+			break
+		}
+	}
+
+	if poll == nil {
+		s.logger.Debug("ignoring poll answer for unknown poll", slog.String("telegram_poll_id", answer.PollID))
+		return
+	}
+
+	// checked and tracked under one lock, so of two answers coming in at once
+	// only one restores the poll and the other votes on the copy it tracks
+	s.mutex.Lock()
+	_, tracked := s.tracked[poll.ID]
+	restore := !tracked && !s.closing[poll.ID] && !poll.DeadLetter && poll.CurrentState() == domain.PollStatePending
+	if restore {
+		s.tracked[poll.ID] = &trackedPoll{poll: poll}
+	}
+	s.mutex.Unlock()
+
+	if !tracked && !restore {
+		s.logger.Warn("poll answer arrived after the poll was closed, not counted",
+			slog.String("poll_id", poll.ID),
+			slog.String("state", string(poll.CurrentState())),
+			slog.Int64("voter_id", answer.Sender.ID))
+		return
+	}
+
+	if restore {
+		s.logger.Warn("poll answer for a poll that was not monitored, restoring it",
+			slog.String("poll_id", poll.ID))
+		s.scheduleMonitored(poll)
+	}
+
+	s.HandlePollAnswer(answer)
 }
 
-func (s *PollMonitorService) processPoll(poll *domain.ActivePoll) {
-	chat := &tb.Chat{ID: poll.ChatID}
-	msg := &tb.Message{ID: poll.MessageID, Chat: chat}
+// caller must hold the mutex
+func (s *PollMonitorService) findTracked(telegramPollID string) *trackedPoll {
+	for _, tracked := range s.tracked {
+		if tracked.poll.TelegramPollID == telegramPollID {
+			return tracked
+		}
+	}
+	return nil
+}
+
+// updates the running tally of a monitored poll and closes it
+// as soon as its outcome can no longer change
+func (s *PollMonitorService) HandlePollUpdate(update *tb.Poll) {
+	if update == nil || update.Closed {
+		return
+	}
 
+	s.mutex.Lock()
+	entry := s.findTracked(update.ID)
+	if entry == nil {
+		s.mutex.Unlock()
+		return
+	}
+
+	entry.options = make([]domain.PollOptionResult, len(update.Options))
+	for i, option := range update.Options {
+		entry.options[i] = domain.PollOptionResult{Text: option.Text, Votes: option.VoterCount}
+	}
+	s.mutex.Unlock()
+
+	s.checkEarlyClose(entry)
+}
+
+// closes the poll if its eligible tally can no longer change the outcome
+func (s *PollMonitorService) checkEarlyClose(entry *trackedPoll) {
+	s.mutex.Lock()
+	if entry.options == nil {
+		// option texts are only known after the first poll update
+		s.mutex.Unlock()
+		return
+	}
+	if len(entry.options) != 2 {
+		// only yes/no polls can be proven decided before they end
+		s.mutex.Unlock()
+		return
+	}
+	poll, raw, members := entry.poll, entry.options, entry.members
+	snapshot := *poll
+	snapshot.Votes = append([]domain.Vote(nil), poll.Votes...)
+	s.mutex.Unlock()
+
+	if members == 0 {
+		count, err := s.bot.Len(&tb.Chat{ID: poll.ChatID})
+		if err != nil {
+			s.logger.Warn("cannot get chat member count",
+				slog.String("poll_id", poll.ID),
+				slog.String("error", err.Error()))
+		} else {
+			members = count
+			s.mutex.Lock()
+			entry.members = count
+			s.mutex.Unlock()
+		}
+	}
+
+	options := s.eligibility.EligibleTally(&snapshot, raw)
+
+	rule := s.quorum.Rule(poll.ChatID, poll.Type)
+	required := rule.MinVotes
+	if members > 0 {
+		required = requiredVotes(rule, members)
+	}
+
+	if !isDecided(rule, required, members, s.earlyCloseLead, options) {
+		return
+	}
+
+	// whoever removes the job from the queue gets to process the poll
+	if !s.scheduler.Cancel(poll.ID) {
+		return
+	}
+
+	s.logger.Info("poll outcome decided, closing early",
+		slog.String("poll_id", poll.ID),
+		slog.String("tally", formatTally(options)))
+
+	// still tracked, answers may be changing the ledger
+	s.mutex.Lock()
+	poll.ClosedEarly = true
+	if err := s.pollStorage.SavePoll(poll); err != nil {
+		s.logger.Error("failed to save early closed poll",
+			slog.String("poll_id", poll.ID),
+			slog.String("error", err.Error()))
+	}
+	s.mutex.Unlock()
+
+	s.schedule(poll, time.Now())
+}
+
+func (s *PollMonitorService) processPoll(poll *domain.ActivePoll) {
 	member, err := utils.DeserializeMember(poll.MemberData)
 	if err != nil {
-		s.logger.Error("failed to deserialize member data", 
+		// retrying won't fix the stored data
+		poll.Attempts++
+		s.deadLetter(poll, fmt.Errorf("failed to deserialize member data: %w", err))
+		return
+	}
+
+	if err := s.runPoll(poll, member); err != nil {
+		s.handleFailure(poll, member, err)
+		return
+	}
+
+	if poll.CurrentState() == domain.PollStateLifting {
+		s.logger.Info("poll action applied until",
 			slog.String("poll_id", poll.ID),
-			slog.String("error", err.Error()))
+			slog.Time("lift_at", poll.LiftAt))
+		s.schedule(poll, poll.LiftAt)
 		return
 	}
 
-	if err := s.processor.ProcessExpiredPoll(poll.Type, msg, member); err != nil {
-		s.logger.Error("failed to process expired poll",
+	// temporary actions were archived when they were applied
+	if poll.LiftAt.IsZero() {
+		s.archivePoll(poll, member, nil)
+	}
+
+	if err := s.pollStorage.DeletePoll(poll.ID); err != nil {
+		s.logger.Error("failed to delete poll from storage",
+			slog.String("poll_id", poll.ID),
+			slog.String("error", err.Error()))
+	}
+}
+
+// walks the poll through its states up to done, or up to lifting if its action
+// is temporary and not over yet. each state is persisted before the step that
+// follows it so a restart doesn't repeat finished steps
+func (s *PollMonitorService) runPoll(poll *domain.ActivePoll, member *tb.ChatMember) error {
+	for {
+		switch state := poll.CurrentState(); state {
+		case domain.PollStatePending:
+			// nothing happened yet, better to retry than to stop the poll without a trace
+			if err := s.setState(poll, domain.PollStateStopping); err != nil {
+				return err
+			}
+
+		case domain.PollStateStopping:
+			outcome, err := s.processor.DecidePoll(poll)
+			if err != nil {
+				return err
+			}
+
+			poll.Outcome = outcome
+			s.logStateError(poll, s.setState(poll, domain.PollStateActing))
+
+		case domain.PollStateActing:
+			announcement, err := s.processor.ApplyOutcome(poll, member, poll.Outcome)
+			if err != nil {
+				return err
+			}
+
+			if poll.LiftAt.IsZero() {
+				s.logStateError(poll, s.setState(poll, domain.PollStateDone))
+				s.processor.Announce(poll, announcement)
+				continue
+			}
+
+			// the record shows up in the history now, not when the action is over
+			s.archivePoll(poll, member, nil)
+			poll.Attempts, poll.LastError = 0, ""
+			s.logStateError(poll, s.setState(poll, domain.PollStateLifting))
+			s.processor.Announce(poll, announcement)
+			return nil
+
+		case domain.PollStateLifting:
+			if time.Now().Before(poll.LiftAt) {
+				return nil
+			}
+
+			announcement, err := s.processor.LiftOutcome(poll, member)
+			if err != nil {
+				return err
+			}
+			s.logStateError(poll, s.setState(poll, domain.PollStateDone))
+			s.processor.Announce(poll, announcement)
+
+		case domain.PollStateDone:
+			return nil
+
+		default:
+			return fmt.Errorf("unknown poll state: %s", state)
+		}
+	}
+}
+
+func (s *PollMonitorService) setState(poll *domain.ActivePoll, state domain.PollState) error {
+	poll.State = state
+	if err := s.pollStorage.SavePoll(poll); err != nil {
+		return fmt.Errorf("failed to save poll state %s: %w", state, err)
+	}
+	return nil
+}
+
+// the step is already taken, a lost state only means it may be repeated after a restart
+func (s *PollMonitorService) logStateError(poll *domain.ActivePoll, err error) {
+	if err != nil {
+		s.logger.Error("failed to persist poll state",
 			slog.String("poll_id", poll.ID),
+			slog.String("state", string(poll.State)),
 			slog.String("error", err.Error()))
+	}
+}
+
+// schedules a retry with backoff, or dead-letters the poll after too many attempts
+func (s *PollMonitorService) handleFailure(poll *domain.ActivePoll, member *tb.ChatMember, processErr error) {
+	poll.Attempts++
+	poll.LastError = processErr.Error()
+
+	if poll.Attempts >= s.retry.MaxAttempts {
+		s.deadLetter(poll, processErr)
 		return
 	}
 
+	delay := s.retry.Delay(poll.Attempts, processErr)
+	s.logger.Warn("failed to process poll, retrying",
+		slog.String("poll_id", poll.ID),
+		slog.Int("attempt", poll.Attempts),
+		slog.String("retry_in", delay.String()),
+		slog.String("error", processErr.Error()))
+
+	if err := s.pollStorage.SavePoll(poll); err != nil {
+		s.logger.Error("failed to save poll attempts",
+			slog.String("poll_id", poll.ID),
+			slog.String("error", err.Error()))
+	}
+
+	// the poll is still open, keep its ledger and tally up to date until the retry.
+	// only once it is saved, answers change the ledger from now on
+	if state := poll.CurrentState(); state == domain.PollStatePending || state == domain.PollStateStopping {
+		s.mutex.Lock()
+		s.tracked[poll.ID] = &trackedPoll{poll: poll, options: poll.LastTally}
+		s.mutex.Unlock()
+	}
+
+	s.schedule(poll, time.Now().Add(delay))
+}
+
+// keeps the poll in storage for admins to inspect instead of retrying it.
+// it goes to the history once it is dropped, or done after a retry
+func (s *PollMonitorService) deadLetter(poll *domain.ActivePoll, processErr error) {
+	poll.LastError = processErr.Error()
+	poll.DeadLetter = true
+
+	s.logger.Error("poll processing failed for good, dead-lettered",
+		slog.String("poll_id", poll.ID),
+		slog.Int("attempts", poll.Attempts),
+		slog.String("error", processErr.Error()))
+
+	s.mutex.Lock()
+	delete(s.tracked, poll.ID)
+	s.mutex.Unlock()
+
+	if err := s.pollStorage.SavePoll(poll); err != nil {
+		s.logger.Error("failed to save dead-lettered poll",
+			slog.String("poll_id", poll.ID),
+			slog.String("error", err.Error()))
+	}
+}
+
+// gives up on a dead-lettered poll and archives it with its last error
+func (s *PollMonitorService) DropPoll(poll *domain.ActivePoll) error {
 	if err := s.pollStorage.DeletePoll(poll.ID); err != nil {
-		s.logger.Error("failed to delete poll from storage", 
+		return fmt.Errorf("failed to delete poll: %w", err)
+	}
+
+	// temporary actions were archived when they were applied
+	if poll.LiftAt.IsZero() {
+		member, _ := utils.DeserializeMember(poll.MemberData)
+		s.archivePoll(poll, member, errors.New(poll.LastError))
+	}
+	return nil
+}
+
+// member and the outcome are missing when processing failed before they were known
+func (s *PollMonitorService) archivePoll(poll *domain.ActivePoll, member *tb.ChatMember, processErr error) {
+	record := &domain.PollRecord{
+		PollID:      poll.ID,
+		Type:        poll.Type,
+		ChatID:      poll.ChatID,
+		MessageID:   poll.MessageID,
+		UserID:      poll.UserID,
+		InitiatorID: poll.InitiatorID,
+		CreatedAt:   poll.CreatedAt,
+		FinishedAt:  time.Now(),
+		ClosedEarly: poll.ClosedEarly,
+	}
+	if !poll.LiftAt.IsZero() {
+		record.ActionDuration = poll.ActionDuration
+	}
+	if outcome := poll.Outcome; outcome != nil {
+		record.Options = outcome.Options
+		record.EligibleOptions = outcome.EligibleOptions
+		record.Decision = outcome.Decision
+		record.Action = outcome.Action
+	}
+	if member != nil && member.User != nil {
+		record.Username = member.User.Username
+	}
+	if processErr != nil {
+		record.Error = processErr.Error()
+	}
+
+	if err := s.pollHistory.AddRecord(record); err != nil {
+		s.logger.Error("failed to archive poll",
 			slog.String("poll_id", poll.ID),
 			slog.String("error", err.Error()))
 	}
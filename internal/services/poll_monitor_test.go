@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
+	tb "gopkg.in/telebot.v4"
+)
+
+// poll monitor on top of the fake api, with the storage behind it
+type testMonitor struct {
+	*PollMonitorService
+	t       *testing.T
+	api     *fakeAPI
+	polls   domain.PollStorage
+	history *memoryHistory
+}
+
+func newTestMonitor(t *testing.T) *testMonitor {
+	t.Helper()
+
+	logger := slog.New(slog.DiscardHandler)
+	dir := t.TempDir()
+	polls, err := utils.NewFilePollStorage(filepath.Join(dir, "active_polls.json"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err := utils.NewFileMemberStorage(filepath.Join(dir, "members.json"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { members.Close() })
+
+	api := &fakeAPI{tally: [2]int{5, 1}}
+	history := &memoryHistory{}
+	config := staticConfig{}
+	kinds := NewPollKinds(api, logger, NewPermissionService(api, logger, history))
+	quorum := NewQuorumService(api, logger, kinds, config)
+	eligibility := NewEligibilityService(members, logger)
+	processor := NewPollProcessorService(api, logger, kinds, quorum, eligibility)
+
+	return &testMonitor{
+		PollMonitorService: NewPollMonitorService(api, logger, polls, history, processor, quorum, eligibility, NewScheduler(logger, 1), NewRetryPolicy(logger)),
+		t:                  t,
+		api:                api,
+		polls:              polls,
+		history:            history,
+	}
+}
+
+// saves an expired ban poll against the test user
+func (m *testMonitor) banPoll(duration time.Duration) *domain.ActivePoll {
+	m.t.Helper()
+
+	memberData, err := utils.SerializeMember(&tb.ChatMember{User: &tb.User{ID: testUserID, Username: "target"}})
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	poll := &domain.ActivePoll{
+		ID:             "poll",
+		Type:           domain.PollTypeBan,
+		ChatID:         testChatID,
+		UserID:         testUserID,
+		ExpiresAt:      time.Now().Add(-time.Second),
+		MemberData:     memberData,
+		State:          domain.PollStatePending,
+		ActionDuration: duration,
+	}
+	if err := m.polls.SavePoll(poll); err != nil {
+		m.t.Fatal(err)
+	}
+	return poll
+}
+
+// the poll as storage has it, nil once it is deleted
+func (m *testMonitor) stored(id string) *domain.ActivePoll {
+	m.t.Helper()
+
+	polls, err := m.polls.GetPolls()
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	for _, poll := range polls {
+		if poll.ID == id {
+			return poll
+		}
+	}
+	return nil
+}
+
+func TestProcessPollPermanentAction(t *testing.T) {
+	m := newTestMonitor(t)
+	// a lost announcement doesn't get the ban repeated
+	m.api.replyErr = errors.New("chat not found")
+
+	m.processPoll(m.banPoll(0))
+
+	if len(m.api.banned) != 1 {
+		t.Fatalf("banned %d times, want once", len(m.api.banned))
+	}
+	if len(m.api.replies) != 1 || !strings.HasPrefix(m.api.replies[0], "BAN") {
+		t.Errorf("announced %q, want the ban", m.api.replies)
+	}
+	if poll := m.stored("poll"); poll != nil {
+		t.Errorf("done poll left in storage in state %s", poll.State)
+	}
+
+	if len(m.history.records) != 1 {
+		t.Fatalf("got %d history records, want 1", len(m.history.records))
+	}
+	record := m.history.records[0]
+	if record.Decision != domain.PollDecisionPassed || record.Action != actionBan || record.Error != "" {
+		t.Errorf("archived %s/%s with error %q, want a passed ban", record.Decision, record.Action, record.Error)
+	}
+}
+
+func TestProcessPollTemporaryAction(t *testing.T) {
+	m := newTestMonitor(t)
+
+	poll := m.banPoll(time.Hour)
+	m.processPoll(poll)
+
+	stored := m.stored("poll")
+	if stored == nil || stored.State != domain.PollStateLifting || stored.LiftAt.IsZero() {
+		t.Fatalf("stored poll is %+v, want it waiting to lift", stored)
+	}
+	if len(m.history.records) != 1 || m.history.records[0].ActionDuration != time.Hour {
+		t.Fatalf("history has %d records, want the ban for 1h as soon as it is applied", len(m.history.records))
+	}
+
+	// the lift job finds the term over
+	poll.LiftAt = time.Now().Add(-time.Second)
+	m.processPoll(poll)
+
+	if len(m.api.banned) != 1 || len(m.api.unbanned) != 1 {
+		t.Errorf("banned %d and unbanned %d times, want once each", len(m.api.banned), len(m.api.unbanned))
+	}
+	if last := m.api.replies[len(m.api.replies)-1]; last != "Бан @target закончился" {
+		t.Errorf("announced %q at the end of the term", last)
+	}
+	if m.stored("poll") != nil {
+		t.Error("lifted poll left in storage")
+	}
+	if len(m.history.records) != 1 {
+		t.Errorf("history has %d records after the lift, want 1", len(m.history.records))
+	}
+}
+
+func TestProcessPollDeadLetter(t *testing.T) {
+	t.Setenv("VOTEBAN_RETRY_MAX_ATTEMPTS", "1")
+	m := newTestMonitor(t)
+	m.api.banErr = errors.New("not enough rights")
+
+	m.processPoll(m.banPoll(0))
+
+	stored := m.stored("poll")
+	if stored == nil || !stored.DeadLetter {
+		t.Fatalf("stored poll is %+v, want it dead-lettered", stored)
+	}
+	// decided once, a retry from /failed goes straight to the action
+	if stored.State != domain.PollStateActing || stored.Outcome == nil || stored.LastError != "not enough rights" {
+		t.Errorf("dead-lettered in state %s with error %q", stored.State, stored.LastError)
+	}
+	if len(m.history.records) != 0 {
+		t.Fatalf("dead-lettered poll archived %d times before it was dropped", len(m.history.records))
+	}
+
+	if err := m.DropPoll(stored); err != nil {
+		t.Fatal(err)
+	}
+	if m.stored("poll") != nil {
+		t.Error("dropped poll left in storage")
+	}
+	if len(m.history.records) != 1 || m.history.records[0].Error != "not enough rights" {
+		t.Errorf("dropped poll archived as %+v, want its error", m.history.records)
+	}
+}
@@ -3,111 +3,245 @@ package services
 import (
 	"fmt"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/uaru-shit/votes/internal/domain"
+	"github.com/uaru-shit/votes/pkg/utils"
 	tb "gopkg.in/telebot.v4"
 )
 
 type PollProcessorService struct {
-	bot    tb.API
-	logger *slog.Logger
-	perms  *PermissionService
+	bot         tb.API
+	logger      *slog.Logger
+	kinds       *domain.PollKinds
+	quorum      *QuorumService
+	eligibility *EligibilityService
 }
 
-func NewPollProcessorService(bot tb.API, logger *slog.Logger, perms *PermissionService) *PollProcessorService {
+func NewPollProcessorService(bot tb.API, logger *slog.Logger, kinds *domain.PollKinds, quorum *QuorumService, eligibility *EligibilityService) *PollProcessorService {
 	return &PollProcessorService{
-		bot:    bot,
-		logger: logger,
-		perms:  perms,
+		bot:         bot,
+		logger:      logger,
+		kinds:       kinds,
+		quorum:      quorum,
+		eligibility: eligibility,
 	}
 }
 
-func (s *PollProcessorService) ProcessExpiredPoll(pollType domain.PollType, msg *tb.Message, member *tb.ChatMember) error {
-	poll, err := s.bot.StopPoll(msg)
+// stops the telegram poll and decides its outcome, the decision only counts eligible votes
+func (s *PollProcessorService) DecidePoll(activePoll *domain.ActivePoll) (*domain.PollOutcome, error) {
+	msg := pollMessage(activePoll)
+
+	options, err := s.stopPoll(activePoll, msg)
 	if err != nil {
-		return fmt.Errorf("failed to stop poll: %w", err)
-	}
-
-	// for polls of type "Запретить/Разрешить"    the first option is   "Запретить"
-	// for polls of type         "Да/Нет"         the first option is      "Да"
-	shouldRestrict := poll.Options[0].VoterCount > poll.Options[1].VoterCount
-
-	switch pollType {
-	case domain.PollTypeBan:
-		return s.processBanResult(msg, member, shouldRestrict)
-	case domain.PollTypeUnban:
-		return s.processUnbanResult(msg, member, shouldRestrict)
-	case domain.PollTypeGifs:
-		return s.processGifsResult(msg, member, shouldRestrict)
-	case domain.PollTypeMedia:
-		return s.processMediaResult(msg, member, shouldRestrict)
-	default:
-		return fmt.Errorf("unknown poll type: %s", pollType)
+		return nil, err
+	}
+
+	outcome := &domain.PollOutcome{
+		Options: options,
+		Action:  actionNone,
+	}
+	outcome.EligibleOptions = s.eligibility.EligibleTally(activePoll, outcome.Options)
+
+	rule := s.quorum.Rule(activePoll.ChatID, activePoll.Type)
+	outcome.RequiredVotes = s.quorum.RequiredVotes(msg.Chat, rule)
+	if multi, ok := s.kinds.Get(activePoll.Type).(domain.MultiOptionKind); ok {
+		outcome.Decision, outcome.Choice = multi.Decide(outcome.RequiredVotes, outcome.EligibleOptions)
+	} else {
+		outcome.Decision = decideTally(rule, outcome.RequiredVotes, outcome.EligibleOptions)
 	}
+
+	s.logger.Info("poll decided",
+		slog.String("poll_id", activePoll.ID),
+		slog.String("type", string(activePoll.Type)),
+		slog.String("decision", string(outcome.Decision)),
+		slog.String("tally", formatTally(outcome.Options)),
+		slog.String("eligible_tally", formatTally(outcome.EligibleOptions)))
+
+	return outcome, nil
 }
 
-func (s *PollProcessorService) processBanResult(msg *tb.Message, member *tb.ChatMember, shouldBan bool) error {
-	if shouldBan {
-		return s.handleBan(msg, member)
+// acts on a decided outcome: a passed poll triggers the action of its type,
+// anything else only reports the tally. returns the announcement to send with
+// Announce once the poll is saved. safe to call again if it failed
+func (s *PollProcessorService) ApplyOutcome(activePoll *domain.ActivePoll, member *tb.ChatMember, outcome *domain.PollOutcome) (string, error) {
+	msg := pollMessage(activePoll)
+
+	if outcome.Decision != domain.PollDecisionPassed {
+		outcome.Action = actionNone
+		return noActionReport(outcome), nil
+	}
+
+	summary := formatSummary(outcome)
+
+	kind := s.kinds.Get(activePoll.Type)
+	if kind == nil {
+		return "", fmt.Errorf("unknown poll type: %s", activePoll.Type)
 	}
-	return s.handleUnban(msg, member)
+
+	// the duration of a multi-option poll comes with the winning option
+	if multi, ok := kind.(domain.MultiOptionKind); ok {
+		activePoll.ActionDuration = multi.OptionDuration(outcome.Choice)
+	} else if !kind.TakesDuration() {
+		activePoll.ActionDuration = 0
+	}
+
+	// telegram lifts the restriction by itself at until, the lift job announces it
+	var until time.Time
+	if activePoll.ActionDuration > 0 {
+		until = time.Now().Add(activePoll.ActionDuration)
+		summary = fmt.Sprintf("Срок: %s\n%s", utils.FormatDuration(activePoll.ActionDuration), summary)
+	}
+
+	result, err := kind.Apply(domain.PollAction{
+		PollID:  activePoll.ID,
+		Message: msg,
+		Member:  member,
+		Until:   until,
+		Summary: summary,
+		Option:  outcome.Choice,
+	})
+	outcome.Action = result.Action
+	if err != nil {
+		return "", err
+	}
+
+	activePoll.LiftAt = until
+	return result.Announcement, nil
 }
 
-func (s *PollProcessorService) processUnbanResult(msg *tb.Message, member *tb.ChatMember, shouldUnban bool) error {
-	if shouldUnban {
-		return s.handleUnban(msg, member)
+// undoes the action of a poll with a limited duration once it is over,
+// returns the announcement to send with Announce
+func (s *PollProcessorService) LiftOutcome(activePoll *domain.ActivePoll, member *tb.ChatMember) (string, error) {
+	kind := s.kinds.Get(activePoll.Type)
+	if kind == nil {
+		return "", fmt.Errorf("unknown poll type: %s", activePoll.Type)
 	}
-	return s.handleBan(msg, member)
+
+	return kind.Lift(domain.PollAction{
+		PollID:  activePoll.ID,
+		Message: pollMessage(activePoll),
+		Member:  member,
+		Option:  activePoll.Outcome.Choice,
+	})
 }
 
-func (s *PollProcessorService) processGifsResult(msg *tb.Message, member *tb.ChatMember, shouldMute bool) error {
-	if shouldMute {
-		return s.perms.UpdatePermission(msg, member, "CanSendOther", false, 
-			"Чота не могу отключить стикеры", "-брейнрот")
+// a poll left in the stopping state may have been stopped right before a restart,
+// telegram won't return its tally again so the last one seen is used instead
+func (s *PollProcessorService) stopPoll(activePoll *domain.ActivePoll, msg *tb.Message) ([]domain.PollOptionResult, error) {
+	poll, err := s.bot.StopPoll(msg)
+	if err != nil {
+		if activePoll.CurrentState() != domain.PollStateStopping || !isPollAlreadyClosed(err) {
+			return nil, fmt.Errorf("failed to stop poll: %w", err)
+		}
+
+		// no tally was ever seen for the poll, it is counted as no votes
+		tally := activePoll.LastTally
+		if tally == nil {
+			kind := s.kinds.Get(activePoll.Type)
+			if kind == nil {
+				return nil, fmt.Errorf("failed to stop poll: %w", err)
+			}
+			tally = make([]domain.PollOptionResult, len(kind.Options()))
+			for i, option := range kind.Options() {
+				tally[i] = domain.PollOptionResult{Text: option}
+			}
+		}
+
+		s.logger.Warn("poll was already stopped, using last known tally",
+			slog.String("poll_id", activePoll.ID),
+			slog.String("tally", formatTally(tally)))
+		return tally, nil
+	}
+
+	options := make([]domain.PollOptionResult, len(poll.Options))
+	for i, option := range poll.Options {
+		options[i] = domain.PollOptionResult{Text: option.Text, Votes: option.VoterCount}
 	}
-	return s.perms.UpdatePermission(msg, member, "CanSendOther", true, 
-		"Чота не могу включить стикеры", "Брейнрот снова доступен")
+	return options, nil
 }
 
-func (s *PollProcessorService) processMediaResult(msg *tb.Message, member *tb.ChatMember, shouldMute bool) error {
-	if shouldMute {
-		return s.perms.UpdatePermission(msg, member, "CanSendMedia", false, 
-			"Чота не могу отключить медиа", "Медиа заблокированы")
+func isPollAlreadyClosed(err error) bool {
+	return strings.Contains(err.Error(), "poll has already been closed")
+}
+
+func memberName(member *tb.ChatMember) string {
+	if member.User.Username != "" {
+		return "@" + member.User.Username
 	}
-	return s.perms.UpdatePermission(msg, member, "CanSendMedia", true, 
-		"Чота не могу включить медиа", "Медиа снова доступны")
+	return member.User.FirstName
 }
 
-func (s *PollProcessorService) handleBan(msg *tb.Message, member *tb.ChatMember) error {
-	if err := s.bot.Ban(msg.Chat, member); err != nil {
-		s.logger.Error("cannot ban user", slog.String("error", err.Error()))
-		_, replyErr := s.bot.Reply(msg, "Чота не могу забанить")
-		if replyErr != nil {
-			s.logger.Error("failed to send error message", slog.String("error", replyErr.Error()))
-		}
-		return err
+func pollMessage(activePoll *domain.ActivePoll) *tb.Message {
+	return &tb.Message{ID: activePoll.MessageID, Chat: &tb.Chat{ID: activePoll.ChatID}}
+}
+
+// for polls of type "Запретить/Разрешить"    the first option is   "Запретить"
+// for polls of type         "Да/Нет"         the first option is      "Да"
+// so the poll passes when the first option wins with the required majority
+func decideTally(rule domain.QuorumRule, required int, options []domain.PollOptionResult) domain.PollDecision {
+	total := 0
+	for _, option := range options {
+		total += option.Votes
 	}
 
-	_, err := s.bot.Reply(msg, "BAN B AN BAN BAN BANBANBANBAN BAN BANBANBA NB ANBANB ANBANB ANBANB ANBAN BAN BANBA NBNBANBANB AN BA NBA NBANBA NB ANB ANB AN BANB AN\n!!!!!!!!\n!!!!!!!!!!!!!!!!!!!!\n!!!!!!!!!!!!!!!!!\n!!!!!!!!!!!!!!!!!!\n!!!!!!!!\n!!!!!!!!!!!!")
-	if err != nil {
-		s.logger.Error("failed to reply to poll", slog.String("error", err.Error()))
+	switch {
+	case total == 0 || total < required:
+		return domain.PollDecisionNoQuorum
+	case options[0].Votes == options[1].Votes:
+		return domain.PollDecisionTied
+	case hasMajority(rule, options[0].Votes, total):
+		return domain.PollDecisionPassed
+	default:
+		return domain.PollDecisionRejected
 	}
-	return err
 }
 
-func (s *PollProcessorService) handleUnban(msg *tb.Message, member *tb.ChatMember) error {
-	if err := s.bot.Unban(msg.Chat, member.User, true); err != nil {
-		s.logger.Error("cannot unban user", slog.String("error", err.Error()))
-		_, replyErr := s.bot.Reply(msg, "Чота не могу разбанить")
-		if replyErr != nil {
-			s.logger.Error("failed to send error message", slog.String("error", replyErr.Error()))
-		}
-		return err
+// replies the announcement to the poll. the action is done and saved by now,
+// a failed reply is only logged so it doesn't get the action repeated
+func (s *PollProcessorService) Announce(activePoll *domain.ActivePoll, text string) {
+	if text == "" {
+		return
 	}
 
-	_, err := s.bot.Reply(msg, "Разбанен")
-	if err != nil {
-		s.logger.Error("failed to reply to poll", slog.String("error", err.Error()))
+	if _, err := s.bot.Reply(pollMessage(activePoll), text, tb.AllowWithoutReply); err != nil {
+		s.logger.Error("failed to announce poll result",
+			slog.String("poll_id", activePoll.ID),
+			slog.String("error", err.Error()))
+	}
+}
+
+func noActionReport(outcome *domain.PollOutcome) string {
+	tally := formatSummary(outcome)
+
+	var text string
+	switch outcome.Decision {
+	case domain.PollDecisionNoQuorum:
+		text = formatNoQuorum(tally, outcome.RequiredVotes)
+	case domain.PollDecisionTied:
+		text = fmt.Sprintf("Ничья: %s. Ничего не делаем", tally)
+	default:
+		text = fmt.Sprintf("Голосование не прошло: %s. Ничего не делаем", tally)
+	}
+
+	return text
+}
+
+// raw tally, followed by the eligible one when some votes were not counted
+func formatSummary(outcome *domain.PollOutcome) string {
+	raw := formatTally(outcome.Options)
+	eligible := formatTally(outcome.EligibleOptions)
+	if raw == eligible {
+		return raw
+	}
+	return fmt.Sprintf("%s, засчитано: %s", raw, eligible)
+}
+
+func formatTally(options []domain.PollOptionResult) string {
+	parts := make([]string, len(options))
+	for i, option := range options {
+		parts[i] = fmt.Sprintf("%s %d", option.Text, option.Votes)
 	}
-	return err
+	return strings.Join(parts, " / ")
 }
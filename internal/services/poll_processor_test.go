@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+func TestDecideTally(t *testing.T) {
+	simple := domain.QuorumRule{Supermajority: 0.5}
+
+	tests := []struct {
+		name     string
+		rule     domain.QuorumRule
+		required int
+		options  []domain.PollOptionResult
+		want     domain.PollDecision
+	}{
+		{"no votes", simple, 0, tally(0, 0), domain.PollDecisionNoQuorum},
+		{"short of quorum", simple, 5, tally(3, 1), domain.PollDecisionNoQuorum},
+		{"tied", simple, 2, tally(2, 2), domain.PollDecisionTied},
+		{"passed", simple, 2, tally(3, 1), domain.PollDecisionPassed},
+		{"rejected", simple, 2, tally(1, 3), domain.PollDecisionRejected},
+		{
+			"majority short of supermajority",
+			domain.QuorumRule{Supermajority: 0.75}, 2, tally(3, 2), domain.PollDecisionRejected,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideTally(tt.rule, tt.required, tt.options); got != tt.want {
+				t.Errorf("decideTally() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+// how the winning punishment is picked
+const (
+	// the option with the most votes, a tie changes nothing
+	punishRulePlurality = "plurality"
+	// the median vote by severity, half of the voters wanted at least that much
+	punishRuleMedian = "median"
+)
+
+// option of the punish poll, options go from the mildest to the harshest
+type punishOption struct {
+	text string
+	// nil for the "nothing" option
+	kind     domain.PollKind
+	duration time.Duration
+}
+
+// one poll to choose between doing nothing, a mute, a kick and a ban
+type punishKind struct {
+	pollKindInfo
+	rule    string
+	choices []punishOption
+}
+
+func newPunishKind(logger *slog.Logger, mute, kick, ban domain.PollKind) *punishKind {
+	choices := []punishOption{
+		{text: "Ничего"},
+		{text: "Мут на час", kind: mute, duration: time.Hour},
+		{text: "Мут на день", kind: mute, duration: 24 * time.Hour},
+		{text: "Кик", kind: kick},
+		{text: "Бан", kind: ban},
+	}
+
+	options := make([]string, len(choices))
+	for i, choice := range choices {
+		options[i] = choice.text
+	}
+
+	kind := &punishKind{
+		pollKindInfo: pollKindInfo{
+			pollType: domain.PollTypePunish,
+			commands: []string{"punish", "votepunish"},
+			help:     "Start vote to choose a punishment, from nothing to a ban",
+			name:     "наказание",
+			question: "Что с ним делаем?",
+			options:  options,
+		},
+		rule:    punishRuleMedian, // def
+		choices: choices,
+	}
+
+	// conf
+	if rule := os.Getenv("VOTEBAN_PUNISH_RULE"); rule != "" {
+		if rule == punishRulePlurality || rule == punishRuleMedian {
+			kind.rule = rule
+		} else {
+			logger.Warn("invalid VOTEBAN_PUNISH_RULE in environment", slog.String("value", rule))
+		}
+	}
+
+	return kind
+}
+
+// quorum counts as usual, the majority rules don't apply to more than two options
+func (k *punishKind) Decide(required int, options []domain.PollOptionResult) (domain.PollDecision, int) {
+	total := 0
+	for _, option := range options {
+		total += option.Votes
+	}
+
+	if total == 0 || total < required {
+		return domain.PollDecisionNoQuorum, -1
+	}
+
+	var choice int
+	if k.rule == punishRulePlurality {
+		var tied bool
+		if choice, tied = pluralityOption(options); tied {
+			return domain.PollDecisionTied, -1
+		}
+	} else {
+		choice = medianOption(options, total)
+	}
+
+	if k.choices[choice].kind == nil {
+		return domain.PollDecisionRejected, choice
+	}
+	return domain.PollDecisionPassed, choice
+}
+
+func (k *punishKind) OptionDuration(option int) time.Duration {
+	return k.choices[option].duration
+}
+
+func (k *punishKind) Apply(action domain.PollAction) (domain.PollActionResult, error) {
+	choice := k.choices[action.Option]
+	if choice.kind == nil {
+		return domain.PollActionResult{Action: actionNone}, nil
+	}
+	return choice.kind.Apply(action)
+}
+
+func (k *punishKind) Lift(action domain.PollAction) (string, error) {
+	choice := k.choices[action.Option]
+	if choice.kind == nil {
+		return "", nil
+	}
+	return choice.kind.Lift(action)
+}
+
+func pluralityOption(options []domain.PollOptionResult) (int, bool) {
+	best, tied := 0, false
+	for i := 1; i < len(options); i++ {
+		switch {
+		case options[i].Votes > options[best].Votes:
+			best, tied = i, false
+		case options[i].Votes == options[best].Votes:
+			tied = true
+		}
+	}
+	return best, tied
+}
+
+// lower median, with an even number of votes the milder of the two middle ones wins
+func medianOption(options []domain.PollOptionResult, total int) int {
+	middle := (total - 1) / 2
+	seen := 0
+	for i, option := range options {
+		seen += option.Votes
+		if seen > middle {
+			return i
+		}
+	}
+	return len(options) - 1
+}
@@ -0,0 +1,52 @@
+package services
+
+import "testing"
+
+func TestMedianOption(t *testing.T) {
+	tests := []struct {
+		name  string
+		votes []int
+		want  int
+	}{
+		{"single vote", []int{0, 1, 0}, 1},
+		{"odd count", []int{1, 1, 1}, 1},
+		{"even count takes the milder middle", []int{1, 0, 1}, 0},
+		{"harshest majority", []int{1, 0, 4}, 2},
+		{"mild majority", []int{3, 1, 1}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total := 0
+			for _, v := range tt.votes {
+				total += v
+			}
+			if got := medianOption(tally(tt.votes...), total); got != tt.want {
+				t.Errorf("medianOption(%v) = %d, want %d", tt.votes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluralityOption(t *testing.T) {
+	tests := []struct {
+		name     string
+		votes    []int
+		want     int
+		wantTied bool
+	}{
+		{"clear winner", []int{1, 4, 2}, 1, false},
+		{"first wins", []int{3, 1, 2}, 0, false},
+		{"tie for the lead", []int{2, 2, 1}, 0, true},
+		{"tie below the lead", []int{1, 1, 3}, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, tied := pluralityOption(tally(tt.votes...))
+			if got != tt.want || tied != tt.wantTied {
+				t.Errorf("pluralityOption(%v) = %d, %v, want %d, %v", tt.votes, got, tied, tt.want, tt.wantTied)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+var defaultQuorumRule = domain.QuorumRule{
+	MinVotes:      0,
+	MinShare:      0,
+	Supermajority: 0.5,
+}
+
+type QuorumService struct {
+	bot         tb.API
+	logger      *slog.Logger
+	config      domain.ConfigProvider
+	defaultRule domain.QuorumRule
+	rules       map[domain.PollType]domain.QuorumRule
+}
+
+func NewQuorumService(bot tb.API, logger *slog.Logger, kinds *domain.PollKinds, config domain.ConfigProvider) *QuorumService {
+	service := &QuorumService{
+		bot:    bot,
+		logger: logger,
+		config: config,
+		rules:  make(map[domain.PollType]domain.QuorumRule),
+	}
+
+	// conf: VOTEBAN_QUORUM_* applies to every poll type, VOTEBAN_QUORUM_<TYPE>_* overrides it
+	service.defaultRule = loadQuorumRule(logger, "VOTEBAN_QUORUM_", defaultQuorumRule)
+
+	for _, kind := range kinds.All() {
+		prefix := "VOTEBAN_QUORUM_" + strings.ToUpper(string(kind.Type())) + "_"
+		service.rules[kind.Type()] = loadQuorumRule(logger, prefix, service.defaultRule)
+	}
+
+	return service
+}
+
+// rule of the poll type, with the minimum number of votes the chat may have set in /settings
+func (s *QuorumService) Rule(chatID int64, pollType domain.PollType) domain.QuorumRule {
+	rule, ok := s.rules[pollType]
+	if !ok {
+		rule = s.defaultRule
+	}
+
+	if settings := s.config.Settings(chatID); settings.MinVotes > 0 {
+		rule.MinVotes = settings.MinVotes
+	}
+	return rule
+}
+
+// number of votes the poll needs in this chat for its result to count
+func (s *QuorumService) RequiredVotes(chat *tb.Chat, rule domain.QuorumRule) int {
+	if rule.MinShare <= 0 {
+		return rule.MinVotes
+	}
+
+	members, err := s.bot.Len(chat)
+	if err != nil {
+		s.logger.Warn("cannot get chat member count, checking absolute quorum only",
+			slog.Int64("chat_id", chat.ID),
+			slog.String("error", err.Error()))
+		return rule.MinVotes
+	}
+
+	return requiredVotes(rule, members)
+}
+
+func requiredVotes(rule domain.QuorumRule, members int) int {
+	byShare := int(math.Ceil(rule.MinShare * float64(members)))
+	return max(rule.MinVotes, byShare)
+}
+
+// whether the first option won with the required majority:
+// more than half of the votes for a simple majority, at least the ratio for a supermajority
+func hasMajority(rule domain.QuorumRule, votesFor, total int) bool {
+	if total == 0 {
+		return false
+	}
+	if rule.Supermajority <= 0.5 {
+		return votesFor*2 > total
+	}
+	return float64(votesFor) >= float64(total)*rule.Supermajority
+}
+
+// whether the outcome of a running poll can no longer change: either the remaining
+// members can't flip it, or the lead reached the configured early close margin
+func isDecided(rule domain.QuorumRule, required, members, lead int, options []domain.PollOptionResult) bool {
+	votesFor, total := options[0].Votes, 0
+	for _, option := range options {
+		total += option.Votes
+	}
+	votesAgainst := total - votesFor
+
+	// member count is unknown, nothing can be proven
+	if members > 0 {
+		// the bot itself can't vote
+		remaining := max(0, members-total-1)
+
+		// passes even if everyone else votes against
+		if total >= required && hasMajority(rule, votesFor, total+remaining) {
+			return true
+		}
+
+		// fails even if everyone else votes for
+		if !hasMajority(rule, votesFor+remaining, total+remaining) {
+			return true
+		}
+	}
+
+	if lead > 0 && total >= required && total > 0 {
+		diff := votesFor - votesAgainst
+		if diff < 0 {
+			diff = -diff
+		}
+		return diff >= lead
+	}
+
+	return false
+}
+
+func loadQuorumRule(logger *slog.Logger, prefix string, rule domain.QuorumRule) domain.QuorumRule {
+	if value := os.Getenv(prefix + "MIN_VOTES"); value != "" {
+		if minVotes, err := strconv.Atoi(value); err == nil && minVotes >= 0 {
+			rule.MinVotes = minVotes
+		} else {
+			logger.Warn("invalid quorum min votes in environment", slog.String("variable", prefix+"MIN_VOTES"), slog.String("value", value))
+		}
+	}
+
+	if value := os.Getenv(prefix + "MIN_SHARE"); value != "" {
+		if share, err := strconv.ParseFloat(value, 64); err == nil && share >= 0 && share <= 1 {
+			rule.MinShare = share
+		} else {
+			logger.Warn("invalid quorum min share in environment", slog.String("variable", prefix+"MIN_SHARE"), slog.String("value", value))
+		}
+	}
+
+	if value := os.Getenv(prefix + "SUPERMAJORITY"); value != "" {
+		if ratio, err := strconv.ParseFloat(value, 64); err == nil && ratio >= 0.5 && ratio <= 1 {
+			rule.Supermajority = ratio
+		} else {
+			logger.Warn("invalid quorum supermajority in environment", slog.String("variable", prefix+"SUPERMAJORITY"), slog.String("value", value))
+		}
+	}
+
+	return rule
+}
+
+func formatNoQuorum(tally string, required int) string {
+	if required <= 0 {
+		return fmt.Sprintf("Никто не проголосовал: %s. Ничего не делаем", tally)
+	}
+	return fmt.Sprintf("Недостаточно голосов: %s, нужно хотя бы %d. Ничего не делаем", tally, required)
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+func tally(votes ...int) []domain.PollOptionResult {
+	options := make([]domain.PollOptionResult, len(votes))
+	for i, v := range votes {
+		options[i] = domain.PollOptionResult{Votes: v}
+	}
+	return options
+}
+
+func TestRequiredVotes(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    domain.QuorumRule
+		members int
+		want    int
+	}{
+		{"absolute only", domain.QuorumRule{MinVotes: 5}, 100, 5},
+		{"share above minimum", domain.QuorumRule{MinVotes: 5, MinShare: 0.1}, 100, 10},
+		{"share below minimum", domain.QuorumRule{MinVotes: 5, MinShare: 0.1}, 20, 5},
+		{"share rounds up", domain.QuorumRule{MinShare: 0.1}, 101, 11},
+		{"no members", domain.QuorumRule{MinVotes: 3, MinShare: 0.5}, 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requiredVotes(tt.rule, tt.members); got != tt.want {
+				t.Errorf("requiredVotes() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasMajority(t *testing.T) {
+	simple := domain.QuorumRule{Supermajority: 0.5}
+	twoThirds := domain.QuorumRule{Supermajority: 2.0 / 3}
+
+	tests := []struct {
+		name     string
+		rule     domain.QuorumRule
+		votesFor int
+		total    int
+		want     bool
+	}{
+		{"no votes", simple, 0, 0, false},
+		{"simple majority", simple, 3, 5, true},
+		{"simple half is not enough", simple, 2, 4, false},
+		{"unset supermajority is simple", domain.QuorumRule{}, 3, 5, true},
+		{"supermajority reached", twoThirds, 4, 6, true},
+		{"supermajority missed", twoThirds, 3, 5, false},
+		{"all for", twoThirds, 5, 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasMajority(tt.rule, tt.votesFor, tt.total); got != tt.want {
+				t.Errorf("hasMajority(%d, %d) = %v, want %v", tt.votesFor, tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDecided(t *testing.T) {
+	simple := domain.QuorumRule{Supermajority: 0.5}
+
+	tests := []struct {
+		name     string
+		rule     domain.QuorumRule
+		required int
+		members  int
+		lead     int
+		options  []domain.PollOptionResult
+		want     bool
+	}{
+		// 10 members, the bot and 6 voters leave 3
+		{"passes whatever the rest votes", simple, 3, 10, 0, tally(6, 0), true},
+		{"rest can still flip it", simple, 3, 10, 0, tally(4, 2), false},
+		{"fails whatever the rest votes", simple, 3, 10, 0, tally(0, 6), true},
+		{"passing but short of quorum", simple, 8, 10, 0, tally(6, 0), false},
+		{"unknown member count proves nothing", simple, 3, 0, 0, tally(6, 0), false},
+		{"lead reached", simple, 3, 0, 3, tally(4, 1), true},
+		{"lead reached against", simple, 3, 0, 3, tally(1, 4), true},
+		{"lead missed", simple, 3, 0, 3, tally(3, 1), false},
+		{"lead before quorum", simple, 10, 0, 3, tally(4, 0), false},
+		{"no votes", simple, 0, 0, 1, tally(0, 0), false},
+		{
+			"supermajority out of reach",
+			domain.QuorumRule{Supermajority: 2.0 / 3}, 3, 10, 0, tally(2, 4), true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isDecided(tt.rule, tt.required, tt.members, tt.lead, tt.options)
+			if got != tt.want {
+				t.Errorf("isDecided() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	tb "gopkg.in/telebot.v4"
+)
+
+// returned instead of calling telegram when a low-priority call is dropped
+var ErrRateLimited = errors.New("rate limited, call dropped")
+
+const (
+	// telegram allows about 30 requests per second in total and 20 messages
+	// per minute in a group, the defaults stay a bit below that
+	defaultGlobalRate = 25.0
+	defaultChatRate   = 18.0
+	chatBurst         = 3.0
+	// chat buckets kept before the idle ones are forgotten
+	maxChatBuckets = 1000
+)
+
+// refills rate tokens per second up to burst. tokens go negative when calls
+// reserve more than there is, the debt is how long the next caller waits
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// time until the bucket has a token again
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// global and per-chat send budgets shared by every RateLimitedAPI.
+// high-priority calls queue for their turn, low-priority ones only go
+// through while more than half of both budgets is left, so they are the first to go
+type RateLimiter struct {
+	logger *slog.Logger
+
+	mutex    sync.Mutex
+	global   *tokenBucket
+	chatRate float64
+	chats    map[int64]*tokenBucket
+}
+
+func NewRateLimiter(logger *slog.Logger) *RateLimiter {
+	globalRate := defaultGlobalRate
+	chatRate := defaultChatRate
+
+	// conf
+	if rateStr := os.Getenv("VOTEBAN_RATE_GLOBAL"); rateStr != "" {
+		if rate, err := strconv.ParseFloat(rateStr, 64); err == nil && rate > 0 {
+			globalRate = rate
+		} else {
+			logger.Warn("invalid VOTEBAN_RATE_GLOBAL in environment", slog.String("value", rateStr))
+		}
+	}
+
+	if rateStr := os.Getenv("VOTEBAN_RATE_CHAT"); rateStr != "" {
+		if rate, err := strconv.ParseFloat(rateStr, 64); err == nil && rate > 0 {
+			chatRate = rate
+		} else {
+			logger.Warn("invalid VOTEBAN_RATE_CHAT in environment", slog.String("value", rateStr))
+		}
+	}
+
+	return &RateLimiter{
+		logger:   logger,
+		global:   newTokenBucket(globalRate, globalRate),
+		chatRate: chatRate / 60,
+		chats:    make(map[int64]*tokenBucket),
+	}
+}
+
+// blocks until the call fits the budgets, or rejects it if it is low priority.
+// chatID 0 only counts against the global budget
+func (l *RateLimiter) acquire(chatID int64, lowPriority bool) error {
+	l.mutex.Lock()
+	now := time.Now()
+
+	buckets := []*tokenBucket{l.global}
+	if chatID != 0 {
+		buckets = append(buckets, l.chatBucket(chatID, now))
+	}
+
+	var wait time.Duration
+	for _, bucket := range buckets {
+		bucket.refill(now)
+		if lowPriority && bucket.tokens < bucket.burst/2+1 {
+			l.mutex.Unlock()
+			return ErrRateLimited
+		}
+		wait = max(wait, bucket.wait())
+	}
+
+	// charged right away, so the calls queued behind this one wait longer
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	l.mutex.Unlock()
+
+	if wait > 0 {
+		l.logger.Debug("rate limited, waiting",
+			slog.Int64("chat_id", chatID),
+			slog.String("wait", wait.String()))
+		time.Sleep(wait)
+	}
+
+	return nil
+}
+
+// telegram knows better, hold the chat off for as long as it asked
+func (l *RateLimiter) observe(chatID int64, err error) {
+	var floodErr tb.FloodError
+	if chatID == 0 || !errors.As(err, &floodErr) {
+		return
+	}
+
+	l.logger.Warn("telegram flood limit hit",
+		slog.Int64("chat_id", chatID),
+		slog.Int("retry_after", floodErr.RetryAfter))
+
+	l.mutex.Lock()
+	bucket := l.chatBucket(chatID, time.Now())
+	bucket.tokens = min(bucket.tokens, -float64(floodErr.RetryAfter)*bucket.rate)
+	l.mutex.Unlock()
+}
+
+// caller must hold the mutex
+func (l *RateLimiter) chatBucket(chatID int64, now time.Time) *tokenBucket {
+	bucket, ok := l.chats[chatID]
+	if ok {
+		return bucket
+	}
+
+	if len(l.chats) >= maxChatBuckets {
+		// a full bucket is the same as a new one
+		for id, b := range l.chats {
+			b.refill(now)
+			if b.tokens >= b.burst {
+				delete(l.chats, id)
+			}
+		}
+	}
+
+	bucket = newTokenBucket(l.chatRate, chatBurst)
+	l.chats[chatID] = bucket
+	return bucket
+}
+
+// tb.API that makes chat calls wait for the RateLimiter budgets.
+// calls it doesn't override go straight to telegram
+type RateLimitedAPI struct {
+	tb.API
+	limiter     *RateLimiter
+	lowPriority bool
+}
+
+func NewRateLimitedAPI(api tb.API, limiter *RateLimiter) *RateLimitedAPI {
+	return &RateLimitedAPI{API: api, limiter: limiter}
+}
+
+// same budgets, but calls are dropped with ErrRateLimited instead of waiting
+// when the budgets run low. for calls nobody misses, like filter deletions
+func (a *RateLimitedAPI) LowPriority() *RateLimitedAPI {
+	return &RateLimitedAPI{API: a.API, limiter: a.limiter, lowPriority: true}
+}
+
+func (a *RateLimitedAPI) Send(to tb.Recipient, what any, opts ...any) (*tb.Message, error) {
+	chatID := recipientChatID(to)
+	if err := a.limiter.acquire(chatID, a.lowPriority); err != nil {
+		return nil, err
+	}
+	msg, err := a.API.Send(to, what, opts...)
+	a.limiter.observe(chatID, err)
+	return msg, err
+}
+
+func (a *RateLimitedAPI) Reply(to *tb.Message, what any, opts ...any) (*tb.Message, error) {
+	chatID := messageChatID(to)
+	if err := a.limiter.acquire(chatID, a.lowPriority); err != nil {
+		return nil, err
+	}
+	msg, err := a.API.Reply(to, what, opts...)
+	a.limiter.observe(chatID, err)
+	return msg, err
+}
+
+func (a *RateLimitedAPI) Edit(msg tb.Editable, what any, opts ...any) (*tb.Message, error) {
+	chatID := editableChatID(msg)
+	if err := a.limiter.acquire(chatID, a.lowPriority); err != nil {
+		return nil, err
+	}
+	edited, err := a.API.Edit(msg, what, opts...)
+	a.limiter.observe(chatID, err)
+	return edited, err
+}
+
+func (a *RateLimitedAPI) Delete(msg tb.Editable) error {
+	chatID := editableChatID(msg)
+	if err := a.limiter.acquire(chatID, a.lowPriority); err != nil {
+		return err
+	}
+	err := a.API.Delete(msg)
+	a.limiter.observe(chatID, err)
+	return err
+}
+
+func (a *RateLimitedAPI) StopPoll(msg tb.Editable, opts ...any) (*tb.Poll, error) {
+	chatID := editableChatID(msg)
+	if err := a.limiter.acquire(chatID, a.lowPriority); err != nil {
+		return nil, err
+	}
+	poll, err := a.API.StopPoll(msg, opts...)
+	a.limiter.observe(chatID, err)
+	return poll, err
+}
+
+// moderation and lookups don't post anything to the chat,
+// only the global budget applies to them
+
+func (a *RateLimitedAPI) Ban(chat *tb.Chat, member *tb.ChatMember, revokeMessages ...bool) error {
+	chatID := chatChatID(chat)
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return err
+	}
+	err := a.API.Ban(chat, member, revokeMessages...)
+	a.limiter.observe(chatID, err)
+	return err
+}
+
+func (a *RateLimitedAPI) Unban(chat *tb.Chat, user *tb.User, forBanned ...bool) error {
+	chatID := chatChatID(chat)
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return err
+	}
+	err := a.API.Unban(chat, user, forBanned...)
+	a.limiter.observe(chatID, err)
+	return err
+}
+
+func (a *RateLimitedAPI) Restrict(chat *tb.Chat, member *tb.ChatMember) error {
+	chatID := chatChatID(chat)
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return err
+	}
+	err := a.API.Restrict(chat, member)
+	a.limiter.observe(chatID, err)
+	return err
+}
+
+func (a *RateLimitedAPI) AdminsOf(chat *tb.Chat) ([]tb.ChatMember, error) {
+	chatID := chatChatID(chat)
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return nil, err
+	}
+	admins, err := a.API.AdminsOf(chat)
+	a.limiter.observe(chatID, err)
+	return admins, err
+}
+
+func (a *RateLimitedAPI) ChatMemberOf(chat, user tb.Recipient) (*tb.ChatMember, error) {
+	chatID := recipientChatID(chat)
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return nil, err
+	}
+	member, err := a.API.ChatMemberOf(chat, user)
+	a.limiter.observe(chatID, err)
+	return member, err
+}
+
+func (a *RateLimitedAPI) Len(chat *tb.Chat) (int, error) {
+	chatID := chatChatID(chat)
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return 0, err
+	}
+	count, err := a.API.Len(chat)
+	a.limiter.observe(chatID, err)
+	return count, err
+}
+
+func (a *RateLimitedAPI) ChatByID(id int64) (*tb.Chat, error) {
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return nil, err
+	}
+	chat, err := a.API.ChatByID(id)
+	a.limiter.observe(id, err)
+	return chat, err
+}
+
+// callback answers aren't tied to a chat budget
+func (a *RateLimitedAPI) Respond(c *tb.Callback, resp ...*tb.CallbackResponse) error {
+	if err := a.limiter.acquire(0, a.lowPriority); err != nil {
+		return err
+	}
+	return a.API.Respond(c, resp...)
+}
+
+// recipients given by @username only count against the global budget
+func recipientChatID(to tb.Recipient) int64 {
+	if to == nil {
+		return 0
+	}
+	chatID, _ := strconv.ParseInt(to.Recipient(), 10, 64)
+	return chatID
+}
+
+func messageChatID(msg *tb.Message) int64 {
+	if msg == nil || msg.Chat == nil {
+		return 0
+	}
+	return msg.Chat.ID
+}
+
+func editableChatID(msg tb.Editable) int64 {
+	if msg == nil {
+		return 0
+	}
+	_, chatID := msg.MessageSig()
+	return chatID
+}
+
+func chatChatID(chat *tb.Chat) int64 {
+	if chat == nil {
+		return 0
+	}
+	return chat.ID
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+
+	tests := []struct {
+		name     string
+		tokens   float64
+		elapsed  time.Duration
+		want     float64
+		wantWait time.Duration
+	}{
+		{"full", 3, 0, 3, 0},
+		{"refill caps at burst", 1, 10 * time.Second, 3, 0},
+		{"partial refill", 0, 250 * time.Millisecond, 0.5, 250 * time.Millisecond},
+		{"one token left", 1, 0, 1, 0},
+		{"debt", -1, 0, -1, time.Second},
+		{"debt paid off", -1, time.Second, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 2 tokens a second, up to 3
+			b := &tokenBucket{rate: 2, burst: 3, tokens: tt.tokens, last: start}
+			b.refill(start.Add(tt.elapsed))
+
+			if b.tokens != tt.want {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.want)
+			}
+			if wait := b.wait(); wait != tt.wantWait {
+				t.Errorf("wait() = %v, want %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
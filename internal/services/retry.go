@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	tb "gopkg.in/telebot.v4"
+)
+
+// exponential backoff with jitter for failed poll processing
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func NewRetryPolicy(logger *slog.Logger) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    30 * time.Minute,
+	}
+
+	// conf
+	if attemptsStr := os.Getenv("VOTEBAN_RETRY_MAX_ATTEMPTS"); attemptsStr != "" {
+		if attempts, err := strconv.Atoi(attemptsStr); err == nil && attempts > 0 {
+			policy.MaxAttempts = attempts
+		} else {
+			logger.Warn("invalid VOTEBAN_RETRY_MAX_ATTEMPTS in environment", slog.String("value", attemptsStr))
+		}
+	}
+
+	if delayStr := os.Getenv("VOTEBAN_RETRY_BASE_DELAY"); delayStr != "" {
+		if delay, err := time.ParseDuration(delayStr); err == nil && delay > 0 {
+			policy.BaseDelay = delay
+		} else {
+			logger.Warn("invalid VOTEBAN_RETRY_BASE_DELAY in environment", slog.String("value", delayStr))
+		}
+	}
+
+	if delayStr := os.Getenv("VOTEBAN_RETRY_MAX_DELAY"); delayStr != "" {
+		if delay, err := time.ParseDuration(delayStr); err == nil && delay > 0 {
+			policy.MaxDelay = delay
+		} else {
+			logger.Warn("invalid VOTEBAN_RETRY_MAX_DELAY in environment", slog.String("value", delayStr))
+		}
+	}
+
+	return policy
+}
+
+// delay before the next attempt, attempt counts the failures so far.
+// telegram's retry_after is a lower bound when the error is a flood error
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+
+	// ±20% so polls that failed together don't retry together
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(delay))
+	delay += jitter
+
+	var floodErr tb.FloodError
+	if errors.As(err, &floodErr) {
+		retryAfter := time.Duration(floodErr.RetryAfter) * time.Second
+		delay = max(delay, retryAfter)
+	}
+
+	return delay
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// job waiting in the scheduler
+type ScheduledJob struct {
+	ID string
+	At time.Time
+}
+
+type scheduledItem struct {
+	ScheduledJob
+	run   func(context.Context)
+	index int
+}
+
+// min-heap of items ordered by At
+type jobQueue []*scheduledItem
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].At.Before(q[j].At) }
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x any) {
+	item := x.(*scheduledItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*q = old[:n-1]
+	return item
+}
+
+// runs jobs at their scheduled time on a bounded pool of workers.
+// a job leaves the queue once it is handed to a worker, after that
+// it can no longer be cancelled or rescheduled
+type Scheduler struct {
+	logger  *slog.Logger
+	workers int
+
+	mutex sync.Mutex
+	queue jobQueue
+	items map[string]*scheduledItem
+
+	wake    chan struct{}
+	jobs    chan *scheduledItem
+	running sync.WaitGroup
+}
+
+func NewScheduler(logger *slog.Logger, workers int) *Scheduler {
+	return &Scheduler{
+		logger:  logger,
+		workers: workers,
+		items:   make(map[string]*scheduledItem),
+		wake:    make(chan struct{}, 1),
+		jobs:    make(chan *scheduledItem),
+	}
+}
+
+// starts dispatching until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	for range s.workers {
+		s.running.Add(1)
+		go s.worker(ctx)
+	}
+
+	s.running.Add(1)
+	go s.dispatch(ctx)
+}
+
+// waits for the dispatcher and the jobs that are running to finish after ctx is cancelled
+func (s *Scheduler) Wait() {
+	s.running.Wait()
+}
+
+// schedules a job, replacing the one with the same id
+func (s *Scheduler) Add(id string, at time.Time, run func(context.Context)) {
+	s.mutex.Lock()
+	if item, ok := s.items[id]; ok {
+		heap.Remove(&s.queue, item.index)
+	}
+
+	item := &scheduledItem{ScheduledJob: ScheduledJob{ID: id, At: at}, run: run}
+	heap.Push(&s.queue, item)
+	s.items[id] = item
+	s.mutex.Unlock()
+
+	s.notify()
+}
+
+// removes a job that hasn't been dispatched yet, reports whether it was found
+func (s *Scheduler) Cancel(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return false
+	}
+
+	heap.Remove(&s.queue, item.index)
+	delete(s.items, id)
+	return true
+}
+
+// moves a job that hasn't been dispatched yet, reports whether it was found
+func (s *Scheduler) Reschedule(id string, at time.Time) bool {
+	s.mutex.Lock()
+	item, ok := s.items[id]
+	if ok {
+		item.At = at
+		heap.Fix(&s.queue, item.index)
+	}
+	s.mutex.Unlock()
+
+	if ok {
+		s.notify()
+	}
+	return ok
+}
+
+// pending jobs, soonest first
+func (s *Scheduler) List() []ScheduledJob {
+	s.mutex.Lock()
+	jobs := make([]ScheduledJob, len(s.queue))
+	for i, item := range s.queue {
+		jobs[i] = item.ScheduledJob
+	}
+	s.mutex.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].At.Before(jobs[j].At)
+	})
+	return jobs
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) dispatch(ctx context.Context) {
+	defer s.running.Done()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		wait := time.Hour
+
+		s.mutex.Lock()
+		if len(s.queue) > 0 {
+			wait = time.Until(s.queue[0].At)
+			if wait <= 0 {
+				item := heap.Pop(&s.queue).(*scheduledItem)
+				delete(s.items, item.ID)
+				s.mutex.Unlock()
+
+				select {
+				case s.jobs <- item:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+		}
+		s.mutex.Unlock()
+
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Scheduler) worker(ctx context.Context) {
+	defer s.running.Done()
+
+	for {
+		select {
+		case item := <-s.jobs:
+			// both cases may be ready at once, don't start new work after shutdown
+			if ctx.Err() != nil {
+				s.logger.Info("scheduler stopped, job dropped", slog.String("job_id", item.ID))
+				return
+			}
+			item.run(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
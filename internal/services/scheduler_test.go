@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// runs the scheduler on one worker and returns the ids of the jobs in the order they ran
+func runScheduler(t *testing.T, setup func(s *Scheduler, run func(id string) func(context.Context)), want int) []string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewScheduler(slog.New(slog.DiscardHandler), 1)
+
+	ran := make(chan string, 16)
+	run := func(id string) func(context.Context) {
+		return func(context.Context) { ran <- id }
+	}
+
+	setup(s, run)
+	s.Start(ctx)
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+
+	var order []string
+	timeout := time.After(2 * time.Second)
+	for len(order) < want {
+		select {
+		case id := <-ran:
+			order = append(order, id)
+		case <-timeout:
+			t.Fatalf("ran %v, want %d jobs", order, want)
+		}
+	}
+
+	// nothing else is supposed to run
+	select {
+	case id := <-ran:
+		t.Fatalf("unexpected job %q after %v", id, order)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	return order
+}
+
+func TestScheduler(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(s *Scheduler, run func(id string) func(context.Context))
+		want  []string
+	}{
+		{
+			name: "runs in time order",
+			setup: func(s *Scheduler, run func(string) func(context.Context)) {
+				now := time.Now()
+				s.Add("c", now.Add(60*time.Millisecond), run("c"))
+				s.Add("a", now.Add(20*time.Millisecond), run("a"))
+				s.Add("b", now.Add(40*time.Millisecond), run("b"))
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "runs overdue jobs right away",
+			setup: func(s *Scheduler, run func(string) func(context.Context)) {
+				now := time.Now()
+				s.Add("late", now.Add(time.Hour), run("late"))
+				s.Add("overdue", now.Add(-time.Minute), run("overdue"))
+			},
+			want: []string{"overdue"},
+		},
+		{
+			name: "add replaces the job with the same id",
+			setup: func(s *Scheduler, run func(string) func(context.Context)) {
+				now := time.Now()
+				s.Add("a", now.Add(20*time.Millisecond), run("old"))
+				s.Add("b", now.Add(40*time.Millisecond), run("b"))
+				s.Add("a", now.Add(60*time.Millisecond), run("new"))
+			},
+			want: []string{"b", "new"},
+		},
+		{
+			name: "cancel drops the job",
+			setup: func(s *Scheduler, run func(string) func(context.Context)) {
+				now := time.Now()
+				s.Add("a", now.Add(20*time.Millisecond), run("a"))
+				s.Add("b", now.Add(40*time.Millisecond), run("b"))
+				if !s.Cancel("a") {
+					t.Error("cancel of a pending job returned false")
+				}
+				if s.Cancel("missing") {
+					t.Error("cancel of an unknown job returned true")
+				}
+			},
+			want: []string{"b"},
+		},
+		{
+			name: "reschedule moves the job",
+			setup: func(s *Scheduler, run func(string) func(context.Context)) {
+				now := time.Now()
+				s.Add("a", now.Add(time.Hour), run("a"))
+				s.Add("b", now.Add(40*time.Millisecond), run("b"))
+				if !s.Reschedule("a", now.Add(20*time.Millisecond)) {
+					t.Error("reschedule of a pending job returned false")
+				}
+				if s.Reschedule("missing", now) {
+					t.Error("reschedule of an unknown job returned true")
+				}
+			},
+			want: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runScheduler(t, tt.setup, len(tt.want))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ran %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerRescheduleWakesDispatcher(t *testing.T) {
+	got := runScheduler(t, func(s *Scheduler, run func(string) func(context.Context)) {
+		s.Add("a", time.Now().Add(time.Hour), run("a"))
+		// the dispatcher is asleep until the hour is up by now
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			s.Reschedule("a", time.Now())
+		}()
+	}, 1)
+
+	if !slices.Equal(got, []string{"a"}) {
+		t.Errorf("ran %v, want [a]", got)
+	}
+}
+
+func TestSchedulerDispatchedJobLeavesQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewScheduler(slog.New(slog.DiscardHandler), 1)
+	s.Start(ctx)
+	defer func() {
+		cancel()
+		s.Wait()
+	}()
+
+	done := make(chan struct{})
+	s.Add("a", time.Now(), func(context.Context) { close(done) })
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("job didn't run")
+	}
+
+	if s.Cancel("a") {
+		t.Error("cancel of a dispatched job returned true")
+	}
+	if s.Reschedule("a", time.Now()) {
+		t.Error("reschedule of a dispatched job returned true")
+	}
+	if jobs := s.List(); len(jobs) != 0 {
+		t.Errorf("list = %v, want empty", jobs)
+	}
+}
+
+func TestSchedulerList(t *testing.T) {
+	s := NewScheduler(slog.New(slog.DiscardHandler), 1)
+	now := time.Now()
+	s.Add("c", now.Add(3*time.Minute), func(context.Context) {})
+	s.Add("a", now.Add(time.Minute), func(context.Context) {})
+	s.Add("d", now.Add(4*time.Minute), func(context.Context) {})
+	s.Add("b", now.Add(2*time.Minute), func(context.Context) {})
+	s.Cancel("d")
+
+	var ids []string
+	for _, job := range s.List() {
+		ids = append(ids, job.ID)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(ids, want) {
+		t.Errorf("list = %v, want %v", ids, want)
+	}
+}
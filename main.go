@@ -2,9 +2,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/uaru-shit/votes/internal/bot"
@@ -48,20 +54,109 @@ func main() {
 	tbBot, err := tb.NewBot(tb.Settings{
 		Token:   token,
 		OnError: eHandler.HandleError,
+		Poller: &tb.LongPoller{
+			Timeout: 10 * time.Second,
+			// chat_member updates are only sent when asked for, they keep the admin cache fresh
+			AllowedUpdates: []string{"message", "callback_query", "poll", "poll_answer", "chat_member", "my_chat_member"},
+		},
 	})
 	if err != nil {
 		log.Error("failed to initialize bot:", utils.ErrorAttr(err))
 		os.Exit(1)
 	}
 
-	// create poll storage
-	pollStorage, err := utils.NewFilePollStorage("data/active_polls.json")
+	// create poll storage, history archive, member activity and chat settings storage
+	storage, err := newStorage(log)
 	if err != nil {
 		log.Error("failed to create poll storage:", utils.ErrorAttr(err))
 		os.Exit(1)
 	}
 
-	b := bot.New(log, tbBot, pollStorage)
+	b := bot.New(log, tbBot, storage)
 
-	b.Start()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b.Start(ctx)
+
+	if err := storage.Close(); err != nil {
+		log.Error("failed to close storage:", utils.ErrorAttr(err))
+		os.Exit(1)
+	}
+}
+
+// picks the storage backend from VOTEBAN_STORAGE (file or sqlite)
+func newStorage(log *slog.Logger) (bot.Storage, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("VOTEBAN_STORAGE")))
+	path := os.Getenv("VOTEBAN_STORAGE_PATH")
+
+	switch backend {
+	case "", "file":
+		if path == "" {
+			path = "data/active_polls.json"
+		}
+		dir := filepath.Dir(path)
+		log.Info("using file poll storage", slog.String("path", path))
+
+		pollStorage, err := utils.NewFilePollStorage(path, log)
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		pollHistory, err := utils.NewFilePollHistory(filepath.Join(dir, "poll_history.jsonl"), log)
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		memberStorage, err := utils.NewFileMemberStorage(filepath.Join(dir, "members.json"), log)
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		settingsStorage, err := utils.NewFileSettingsStorage(filepath.Join(dir, "chat_settings.json"), log)
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		return bot.Storage{
+			Polls:    pollStorage,
+			History:  pollHistory,
+			Members:  memberStorage,
+			Settings: settingsStorage,
+		}, nil
+	case "sqlite":
+		if path == "" {
+			path = "data/votes.db"
+		}
+		log.Info("using sqlite poll storage", slog.String("path", path))
+
+		pollStorage, err := utils.NewSQLitePollStorage(path)
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		pollHistory, err := utils.NewSQLitePollHistory(pollStorage.DB())
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		memberStorage, err := utils.NewSQLiteMemberStorage(pollStorage.DB())
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		settingsStorage, err := utils.NewSQLiteSettingsStorage(pollStorage.DB())
+		if err != nil {
+			return bot.Storage{}, err
+		}
+
+		return bot.Storage{
+			Polls:    pollStorage,
+			History:  pollHistory,
+			Members:  memberStorage,
+			Settings: settingsStorage,
+		}, nil
+	default:
+		return bot.Storage{}, fmt.Errorf("unknown storage backend: %s", backend)
+	}
 }
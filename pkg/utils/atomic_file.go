@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to a temp file in the same directory, syncs it
+// and renames it over path, so readers see either the old or the new content
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(path, data, perm, nil)
+}
+
+// WriteFileAtomicBackup is WriteFileAtomic keeping the old content of path
+// at backup. the backup is only replaced once the new content is on disk,
+// so a failed write leaves both files as they were
+func WriteFileAtomicBackup(path, backup string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(path, data, perm, func() error {
+		return backupFile(path, backup, perm)
+	})
+}
+
+// beforeRename runs once the temp file is synced
+func writeFileAtomic(path string, data []byte, perm os.FileMode, beforeRename func() error) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	// no-op once the rename succeeded
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to chmod temp file: %w", err)
+	}
+
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+// hard links path to backup, copies it where the filesystem has no links.
+// nothing to keep when path doesn't exist yet
+func backupFile(path, backup string, perm os.FileMode) error {
+	tmpPath := backup + ".tmp"
+	os.Remove(tmpPath)
+
+	err := os.Link(path, tmpPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read file for backup: %w", err)
+		}
+		if err := WriteFileAtomic(backup, data, perm); err != nil {
+			return fmt.Errorf("failed to copy backup: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.Rename(tmpPath, backup); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename backup: %w", err)
+	}
+	return nil
+}
+
+// makes the rename itself durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "polls.json")
+	backup := path + ".bak"
+
+	// nothing to back up on the first write
+	if err := WriteFileAtomicBackup(path, backup, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Errorf("backup of a new file exists: %v", err)
+	}
+
+	if err := WriteFileAtomicBackup(path, backup, []byte("two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomicBackup(path, backup, []byte("three"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for file, want := range map[string]string{path: "three", backup: "two"} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s has %q, want %q", filepath.Base(file), data, want)
+		}
+	}
+
+	// temp files are renamed or removed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("directory has %v, want only the file and its backup", names)
+	}
+}
@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+const memberFlushInterval = 30 * time.Second
+
+// implements MemberStorage interface with an in-memory map that is
+// periodically written to a JSON file, every message would be too many writes otherwise
+type FileMemberStorage struct {
+	filePath string
+	logger   *slog.Logger
+
+	mutex   sync.RWMutex
+	members map[string]*domain.MemberActivity
+	// lowercased username to the key in members, telegram can't resolve
+	// usernames of regular members so the bot keeps its own index
+	usernames map[string]string
+	dirty     bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewFileMemberStorage(filePath string, logger *slog.Logger) (*FileMemberStorage, error) {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	storage := &FileMemberStorage{
+		filePath:  filePath,
+		logger:    logger,
+		members:   make(map[string]*domain.MemberActivity),
+		usernames: make(map[string]string),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if len(data) > 0 {
+		var members []*domain.MemberActivity
+		if err := json.Unmarshal(data, &members); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal members: %w", err)
+		}
+		for _, member := range members {
+			key := memberKey(member.ChatID, member.UserID)
+			storage.members[key] = member
+			if member.Username != "" {
+				storage.usernames[usernameKey(member.ChatID, member.Username)] = key
+			}
+		}
+	}
+
+	go storage.flushLoop()
+
+	return storage, nil
+}
+
+func (s *FileMemberStorage) RecordMessage(chatID int64, user *tb.User, at time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := memberKey(chatID, user.ID)
+	member, ok := s.members[key]
+	if !ok {
+		member = &domain.MemberActivity{
+			ChatID:    chatID,
+			UserID:    user.ID,
+			FirstSeen: at,
+		}
+		s.members[key] = member
+	}
+
+	if member.Username != user.Username {
+		if old := usernameKey(chatID, member.Username); member.Username != "" && s.usernames[old] == key {
+			delete(s.usernames, old)
+		}
+		if user.Username != "" {
+			s.usernames[usernameKey(chatID, user.Username)] = key
+		}
+	}
+
+	member.Username = user.Username
+	member.IsBot = user.IsBot
+	member.LastSeen = at
+	member.MessageCount++
+	s.dirty = true
+
+	return nil
+}
+
+func (s *FileMemberStorage) GetMember(chatID, userID int64) (*domain.MemberActivity, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	member, ok := s.members[memberKey(chatID, userID)]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *member
+	return &copied, nil
+}
+
+func (s *FileMemberStorage) FindByUsername(chatID int64, username string) (*domain.MemberActivity, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, ok := s.usernames[usernameKey(chatID, username)]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *s.members[key]
+	return &copied, nil
+}
+
+// stops the background flush and writes pending changes
+func (s *FileMemberStorage) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
+
+func (s *FileMemberStorage) Flush() error {
+	s.mutex.Lock()
+	if !s.dirty {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	members := make([]*domain.MemberActivity, 0, len(s.members))
+	for _, member := range s.members {
+		members = append(members, member)
+	}
+
+	data, err := json.Marshal(members)
+	s.dirty = false
+	s.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to marshal members: %w", err)
+	}
+
+	if err := WriteFileAtomic(s.filePath, data, 0644); err != nil {
+		s.mutex.Lock()
+		s.dirty = true
+		s.mutex.Unlock()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileMemberStorage) flushLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(memberFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.logger.Error("failed to flush member storage", slog.String("error", err.Error()))
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func memberKey(chatID, userID int64) string {
+	return strconv.FormatInt(chatID, 10) + ":" + strconv.FormatInt(userID, 10)
+}
+
+func usernameKey(chatID int64, username string) string {
+	return strconv.FormatInt(chatID, 10) + ":" + strings.ToLower(username)
+}
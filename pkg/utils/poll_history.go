@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+// implements PollHistory interface as an append-only JSON lines file
+type FilePollHistory struct {
+	filePath string
+	logger   *slog.Logger
+	mutex    sync.RWMutex
+}
+
+func NewFilePollHistory(filePath string, logger *slog.Logger) (*FilePollHistory, error) {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	return &FilePollHistory{
+		filePath: filePath,
+		logger:   logger,
+	}, nil
+}
+
+func (h *FilePollHistory) AddRecord(record *domain.PollRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+	data = append(data, '\n')
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	file, err := os.OpenFile(h.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to append record: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	return nil
+}
+
+func (h *FilePollHistory) GetRecordsByChat(chatID int64, limit, offset int) ([]*domain.PollRecord, error) {
+	return h.findRecords(func(record *domain.PollRecord) bool {
+		return record.ChatID == chatID
+	}, limit, offset)
+}
+
+func (h *FilePollHistory) GetRecordsByUser(chatID, userID int64, limit, offset int) ([]*domain.PollRecord, error) {
+	return h.findRecords(func(record *domain.PollRecord) bool {
+		return record.ChatID == chatID && record.UserID == userID
+	}, limit, offset)
+}
+
+func (h *FilePollHistory) findRecords(match func(*domain.PollRecord) bool, limit, offset int) ([]*domain.PollRecord, error) {
+	records, err := h.loadRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	// newest first
+	var matched []*domain.PollRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if match(records[i]) {
+			matched = append(matched, records[i])
+		}
+	}
+
+	if offset >= len(matched) {
+		return nil, nil
+	}
+	matched = matched[offset:]
+
+	if limit > 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
+
+	return matched, nil
+}
+
+func (h *FilePollHistory) loadRecords() ([]*domain.PollRecord, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	data, err := os.ReadFile(h.filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var records []*domain.PollRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record domain.PollRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a torn last line after a crash shouldn't hide the rest of the history
+			h.logger.Warn("skipping unreadable poll history line",
+				slog.String("path", h.filePath),
+				slog.Int("line", line),
+				slog.String("error", err.Error()))
+			continue
+		}
+		records = append(records, &record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan file: %w", err)
+	}
+
+	return records, nil
+}
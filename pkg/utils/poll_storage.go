@@ -2,22 +2,33 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/uaru-shit/votes/internal/domain"
 )
 
-// implements PollStorage interface using JSON files
+// implements PollStorage interface using JSON files.
+// every write goes to a temp file which is synced and renamed over the main one,
+// the previous good state is kept next to it with a .bak suffix
 type FilePollStorage struct {
 	filePath string
+	logger   *slog.Logger
 	mutex    sync.RWMutex
+
+	// set when the main file was unreadable and polls were loaded from backup,
+	// in that case the broken main file must not be rotated over the good backup
+	mainCorrupted atomic.Bool
 }
 
-func NewFilePollStorage(filePath string) (*FilePollStorage, error) {
+func NewFilePollStorage(filePath string, logger *slog.Logger) (*FilePollStorage, error) {
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
@@ -25,12 +36,31 @@ func NewFilePollStorage(filePath string) (*FilePollStorage, error) {
 
 	storage := &FilePollStorage{
 		filePath: filePath,
+		logger:   logger,
 	}
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	_, mainErr := os.Stat(filePath)
+	_, backupErr := os.Stat(storage.backupPath())
+	if os.IsNotExist(mainErr) && os.IsNotExist(backupErr) {
 		if err := storage.savePollsToFile([]*domain.ActivePoll{}); err != nil {
 			return nil, fmt.Errorf("failed to initialize storage file: %w", err)
 		}
+		return storage, nil
+	}
+
+	// repair the main file right away if it is broken, so later writes start from a good state
+	polls, err := storage.loadPollsFromFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load storage file: %w", err)
+	}
+
+	if storage.mainCorrupted.Load() {
+		if err := storage.savePollsToFile(polls); err != nil {
+			return nil, fmt.Errorf("failed to restore storage file from backup: %w", err)
+		}
+		logger.Warn("poll storage restored from backup",
+			slog.String("path", filePath),
+			slog.Int("polls", len(polls)))
 	}
 
 	return storage, nil
@@ -106,6 +136,29 @@ func (s *FilePollStorage) GetPollsByType(pollType domain.PollType) ([]*domain.Ac
 	return filteredPolls, nil
 }
 
+func (s *FilePollStorage) GetPollsByChat(chatID int64) ([]*domain.ActivePoll, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	polls, err := s.loadPollsFromFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load polls: %w", err)
+	}
+
+	var filteredPolls []*domain.ActivePoll
+	for _, poll := range polls {
+		if poll.ChatID == chatID {
+			filteredPolls = append(filteredPolls, poll)
+		}
+	}
+
+	slices.SortFunc(filteredPolls, func(a, b *domain.ActivePoll) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+
+	return filteredPolls, nil
+}
+
 func (s *FilePollStorage) CleanExpiredPolls() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -127,8 +180,32 @@ func (s *FilePollStorage) CleanExpiredPolls() error {
 	return s.savePollsToFile(activePolls)
 }
 
+func (s *FilePollStorage) backupPath() string {
+	return s.filePath + ".bak"
+}
+
 func (s *FilePollStorage) loadPollsFromFile() ([]*domain.ActivePoll, error) {
-	data, err := os.ReadFile(s.filePath)
+	polls, err := readPollsFile(s.filePath)
+	if err == nil {
+		return polls, nil
+	}
+
+	s.logger.Error("POLL STORAGE FILE IS CORRUPTED, falling back to backup",
+		slog.String("path", s.filePath),
+		slog.String("backup", s.backupPath()),
+		slog.String("error", err.Error()))
+
+	polls, backupErr := readPollsFile(s.backupPath())
+	if backupErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("backup: %w", backupErr))
+	}
+
+	s.mainCorrupted.Store(true)
+	return polls, nil
+}
+
+func readPollsFile(path string) ([]*domain.ActivePoll, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
@@ -149,9 +226,16 @@ func (s *FilePollStorage) savePollsToFile(polls []*domain.ActivePoll) error {
 		return fmt.Errorf("failed to marshal polls: %w", err)
 	}
 
-	if err := os.WriteFile(s.filePath, data, 0644); err != nil {
+	// keep the last good state around, unless the current file is the broken one
+	write := func() error { return WriteFileAtomicBackup(s.filePath, s.backupPath(), data, 0644) }
+	if s.mainCorrupted.Load() {
+		write = func() error { return WriteFileAtomic(s.filePath, data, 0644) }
+	}
+
+	if err := write(); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
+	s.mainCorrupted.Store(false)
 	return nil
 }
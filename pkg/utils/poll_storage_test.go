@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+func TestFilePollStorageFallsBackToBackup(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	path := filepath.Join(t.TempDir(), "active_polls.json")
+
+	storage, err := NewFilePollStorage(path, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"first", "second"} {
+		if err := storage.SavePoll(&domain.ActivePoll{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a torn write, as a crash left it before writes were atomic
+	if err := os.WriteFile(path, []byte(`[{"id": "sec`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = NewFilePollStorage(path, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	polls, err := storage.GetPolls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(polls) != 1 || polls[0].ID != "first" {
+		t.Fatalf("got %d polls from the backup, want the first one", len(polls))
+	}
+
+	// the main file is repaired, the next write doesn't rotate the broken one into the backup
+	if _, err := readPollsFile(path); err != nil {
+		t.Errorf("main file is not restored: %v", err)
+	}
+	if err := storage.SavePoll(&domain.ActivePoll{ID: "third"}); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := readPollsFile(path + ".bak")
+	if err != nil || len(backup) != 1 {
+		t.Errorf("backup has %d polls (%v), want the first one", len(backup), err)
+	}
+}
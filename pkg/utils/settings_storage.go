@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+// implements SettingsStorage interface with a JSON file that is
+// rewritten on every change, settings change rarely
+type FileSettingsStorage struct {
+	filePath string
+	logger   *slog.Logger
+
+	mutex    sync.RWMutex
+	settings map[int64]*domain.ChatSettings
+}
+
+func NewFileSettingsStorage(filePath string, logger *slog.Logger) (*FileSettingsStorage, error) {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	storage := &FileSettingsStorage{
+		filePath: filePath,
+		logger:   logger,
+		settings: make(map[int64]*domain.ChatSettings),
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if len(data) > 0 {
+		var settings []*domain.ChatSettings
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal settings: %w", err)
+		}
+		for _, chatSettings := range settings {
+			storage.settings[chatSettings.ChatID] = chatSettings
+		}
+	}
+
+	return storage, nil
+}
+
+func (s *FileSettingsStorage) GetSettings(chatID int64) (*domain.ChatSettings, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	settings, ok := s.settings[chatID]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := settings.Clone()
+	return &copied, nil
+}
+
+func (s *FileSettingsStorage) SaveSettings(settings *domain.ChatSettings) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous, existed := s.settings[settings.ChatID]
+	copied := settings.Clone()
+	s.settings[settings.ChatID] = &copied
+
+	all := make([]*domain.ChatSettings, 0, len(s.settings))
+	for _, chatSettings := range s.settings {
+		all = append(all, chatSettings)
+	}
+
+	err := s.write(all)
+	if err != nil {
+		// keep memory in line with the file
+		if existed {
+			s.settings[settings.ChatID] = previous
+		} else {
+			delete(s.settings, settings.ChatID)
+		}
+	}
+	return err
+}
+
+func (s *FileSettingsStorage) write(settings []*domain.ChatSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+
+	if err := WriteFileAtomic(s.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
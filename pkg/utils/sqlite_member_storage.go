@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	tb "gopkg.in/telebot.v4"
+)
+
+const sqliteMemberSchema = `
+CREATE TABLE IF NOT EXISTS members (
+	chat_id       INTEGER NOT NULL,
+	user_id       INTEGER NOT NULL,
+	username      TEXT    NOT NULL,
+	is_bot        INTEGER NOT NULL,
+	first_seen    INTEGER NOT NULL,
+	last_seen     INTEGER NOT NULL,
+	message_count INTEGER NOT NULL,
+	PRIMARY KEY (chat_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_members_username ON members (chat_id, username COLLATE NOCASE);
+`
+
+// implements MemberStorage interface on the same database as SQLitePollStorage
+type SQLiteMemberStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteMemberStorage(db *sql.DB) (*SQLiteMemberStorage, error) {
+	if _, err := db.Exec(sqliteMemberSchema); err != nil {
+		return nil, fmt.Errorf("failed to initialize members schema: %w", err)
+	}
+
+	return &SQLiteMemberStorage{db: db}, nil
+}
+
+func (s *SQLiteMemberStorage) RecordMessage(chatID int64, user *tb.User, at time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO members (chat_id, user_id, username, is_bot, first_seen, last_seen, message_count)
+		VALUES (?, ?, ?, ?, ?, ?, 1)
+		ON CONFLICT (chat_id, user_id) DO UPDATE SET
+			username = excluded.username,
+			is_bot = excluded.is_bot,
+			last_seen = excluded.last_seen,
+			message_count = message_count + 1`,
+		chatID, user.ID, user.Username, user.IsBot, at.Unix(), at.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to record message: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteMemberStorage) GetMember(chatID, userID int64) (*domain.MemberActivity, error) {
+	return s.queryMember(`
+		SELECT chat_id, user_id, username, is_bot, first_seen, last_seen, message_count
+		FROM members WHERE chat_id = ? AND user_id = ?`, chatID, userID)
+}
+
+// a username that changed hands goes to whoever used it last
+func (s *SQLiteMemberStorage) FindByUsername(chatID int64, username string) (*domain.MemberActivity, error) {
+	if username == "" {
+		return nil, nil
+	}
+
+	return s.queryMember(`
+		SELECT chat_id, user_id, username, is_bot, first_seen, last_seen, message_count
+		FROM members WHERE chat_id = ? AND username = ? COLLATE NOCASE
+		ORDER BY last_seen DESC LIMIT 1`, chatID, username)
+}
+
+func (s *SQLiteMemberStorage) queryMember(query string, args ...any) (*domain.MemberActivity, error) {
+	var (
+		member    domain.MemberActivity
+		firstSeen int64
+		lastSeen  int64
+	)
+
+	err := s.db.QueryRow(query, args...).
+		Scan(&member.ChatID, &member.UserID, &member.Username, &member.IsBot, &firstSeen, &lastSeen, &member.MessageCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	member.FirstSeen = time.Unix(firstSeen, 0)
+	member.LastSeen = time.Unix(lastSeen, 0)
+
+	return &member, nil
+}
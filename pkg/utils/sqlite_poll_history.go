@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+const sqliteHistorySchema = `
+CREATE TABLE IF NOT EXISTS poll_history (
+	seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+	poll_id     TEXT    NOT NULL,
+	type        TEXT    NOT NULL,
+	chat_id     INTEGER NOT NULL,
+	user_id     INTEGER NOT NULL,
+	finished_at INTEGER NOT NULL,
+	data        BLOB    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_poll_history_chat_id ON poll_history (chat_id, seq);
+CREATE INDEX IF NOT EXISTS idx_poll_history_user_id ON poll_history (chat_id, user_id, seq);
+`
+
+// implements PollHistory interface on the same database as SQLitePollStorage
+type SQLitePollHistory struct {
+	db *sql.DB
+}
+
+func NewSQLitePollHistory(db *sql.DB) (*SQLitePollHistory, error) {
+	if _, err := db.Exec(sqliteHistorySchema); err != nil {
+		return nil, fmt.Errorf("failed to initialize history schema: %w", err)
+	}
+
+	return &SQLitePollHistory{db: db}, nil
+}
+
+func (h *SQLitePollHistory) AddRecord(record *domain.PollRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	_, err = h.db.Exec(`
+		INSERT INTO poll_history (poll_id, type, chat_id, user_id, finished_at, data)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		record.PollID, string(record.Type), record.ChatID, record.UserID, record.FinishedAt.Unix(), data)
+	if err != nil {
+		return fmt.Errorf("failed to insert record: %w", err)
+	}
+
+	return nil
+}
+
+func (h *SQLitePollHistory) GetRecordsByChat(chatID int64, limit, offset int) ([]*domain.PollRecord, error) {
+	return h.queryRecords(`
+		SELECT data FROM poll_history
+		WHERE chat_id = ?
+		ORDER BY seq DESC LIMIT ? OFFSET ?`,
+		chatID, sqliteLimit(limit), offset)
+}
+
+func (h *SQLitePollHistory) GetRecordsByUser(chatID, userID int64, limit, offset int) ([]*domain.PollRecord, error) {
+	return h.queryRecords(`
+		SELECT data FROM poll_history
+		WHERE chat_id = ? AND user_id = ?
+		ORDER BY seq DESC LIMIT ? OFFSET ?`,
+		chatID, userID, sqliteLimit(limit), offset)
+}
+
+func (h *SQLitePollHistory) queryRecords(query string, args ...any) ([]*domain.PollRecord, error) {
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query records: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*domain.PollRecord
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan record: %w", err)
+		}
+
+		var record domain.PollRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal record: %w", err)
+		}
+		records = append(records, &record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+
+	return records, nil
+}
+
+// negative LIMIT means no limit in sqlite
+func sqliteLimit(limit int) int {
+	if limit <= 0 {
+		return -1
+	}
+	return limit
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS active_polls (
+	id         TEXT PRIMARY KEY,
+	type       TEXT    NOT NULL,
+	chat_id    INTEGER NOT NULL,
+	user_id    INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	data       BLOB    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_active_polls_chat_id ON active_polls (chat_id);
+CREATE INDEX IF NOT EXISTS idx_active_polls_expires_at ON active_polls (expires_at);
+CREATE INDEX IF NOT EXISTS idx_active_polls_type ON active_polls (type);
+`
+
+// implements PollStorage interface using an embedded SQLite database.
+// indexed fields get their own columns, the full poll is kept as JSON in data
+type SQLitePollStorage struct {
+	db *sql.DB
+}
+
+func NewSQLitePollStorage(filePath string) (*SQLitePollStorage, error) {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(FULL)", filePath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// sqlite allows a single writer anyway, one connection avoids SQLITE_BUSY between our own goroutines
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	return &SQLitePollStorage{db: db}, nil
+}
+
+func (s *SQLitePollStorage) SavePoll(poll *domain.ActivePoll) error {
+	data, err := json.Marshal(poll)
+	if err != nil {
+		return fmt.Errorf("failed to marshal poll: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO active_polls (id, type, chat_id, user_id, expires_at, data)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			type = excluded.type,
+			chat_id = excluded.chat_id,
+			user_id = excluded.user_id,
+			expires_at = excluded.expires_at,
+			data = excluded.data`,
+		poll.ID, string(poll.Type), poll.ChatID, poll.UserID, poll.ExpiresAt.Unix(), data)
+	if err != nil {
+		return fmt.Errorf("failed to save poll: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLitePollStorage) GetPolls() ([]*domain.ActivePoll, error) {
+	return s.queryPolls(`SELECT data FROM active_polls ORDER BY expires_at`)
+}
+
+func (s *SQLitePollStorage) DeletePoll(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM active_polls WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete poll: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLitePollStorage) GetPollsByType(pollType domain.PollType) ([]*domain.ActivePoll, error) {
+	return s.queryPolls(`SELECT data FROM active_polls WHERE type = ? ORDER BY expires_at`, string(pollType))
+}
+
+func (s *SQLitePollStorage) GetPollsByChat(chatID int64) ([]*domain.ActivePoll, error) {
+	return s.queryPolls(`SELECT data FROM active_polls WHERE chat_id = ? ORDER BY expires_at`, chatID)
+}
+
+func (s *SQLitePollStorage) CleanExpiredPolls() error {
+	if _, err := s.db.Exec(`DELETE FROM active_polls WHERE expires_at <= ?`, time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to clean expired polls: %w", err)
+	}
+
+	return nil
+}
+
+// shared with the other sqlite-backed stores
+func (s *SQLitePollStorage) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLitePollStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLitePollStorage) queryPolls(query string, args ...any) ([]*domain.ActivePoll, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query polls: %w", err)
+	}
+	defer rows.Close()
+
+	var polls []*domain.ActivePoll
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan poll: %w", err)
+		}
+
+		var poll domain.ActivePoll
+		if err := json.Unmarshal(data, &poll); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal poll: %w", err)
+		}
+		polls = append(polls, &poll)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read polls: %w", err)
+	}
+
+	return polls, nil
+}
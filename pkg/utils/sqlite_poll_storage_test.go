@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+func pollIDs(polls []*domain.ActivePoll) []string {
+	ids := make([]string, len(polls))
+	for i, poll := range polls {
+		ids[i] = poll.ID
+	}
+	return ids
+}
+
+func TestSQLitePollStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "votes.db")
+	storage, err := NewSQLitePollStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	for _, poll := range []*domain.ActivePoll{
+		{ID: "later", Type: domain.PollTypeBan, ChatID: 1, ExpiresAt: now.Add(2 * time.Hour)},
+		{ID: "sooner", Type: domain.PollTypeBan, ChatID: 1, ExpiresAt: now.Add(time.Hour)},
+		{ID: "other chat", Type: domain.PollTypeKick, ChatID: 2, ExpiresAt: now.Add(3 * time.Hour)},
+		{ID: "expired", Type: domain.PollTypeKick, ChatID: 2, ExpiresAt: now.Add(-time.Hour)},
+	} {
+		if err := storage.SavePoll(poll); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// saving again updates the poll in place
+	if err := storage.SavePoll(&domain.ActivePoll{
+		ID: "sooner", Type: domain.PollTypeBan, ChatID: 1, ExpiresAt: now.Add(time.Hour),
+		Votes: []domain.Vote{{VoterID: 7}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(what string, polls []*domain.ActivePoll, err error, want ...string) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := pollIDs(polls); !slices.Equal(got, want) {
+			t.Errorf("%s: got %v, want %v", what, got, want)
+		}
+	}
+
+	polls, err := storage.GetPolls()
+	check("all", polls, err, "expired", "sooner", "later", "other chat")
+	if len(polls[1].Votes) != 1 || !polls[1].ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("updated poll read back as %+v", polls[1])
+	}
+
+	polls, err = storage.GetPollsByChat(1)
+	check("by chat", polls, err, "sooner", "later")
+	polls, err = storage.GetPollsByType(domain.PollTypeKick)
+	check("by type", polls, err, "expired", "other chat")
+
+	if err := storage.CleanExpiredPolls(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.DeletePoll("later"); err != nil {
+		t.Fatal(err)
+	}
+
+	// polls outlive a restart
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	storage, err = NewSQLitePollStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	polls, err = storage.GetPolls()
+	check("after reopening", polls, err, "sooner", "other chat")
+}
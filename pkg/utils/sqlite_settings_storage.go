@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/uaru-shit/votes/internal/domain"
+)
+
+const sqliteSettingsSchema = `
+CREATE TABLE IF NOT EXISTS chat_settings (
+	chat_id INTEGER PRIMARY KEY,
+	data    BLOB    NOT NULL
+);
+`
+
+// implements SettingsStorage interface on the same database as SQLitePollStorage
+type SQLiteSettingsStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteSettingsStorage(db *sql.DB) (*SQLiteSettingsStorage, error) {
+	if _, err := db.Exec(sqliteSettingsSchema); err != nil {
+		return nil, fmt.Errorf("failed to initialize settings schema: %w", err)
+	}
+
+	return &SQLiteSettingsStorage{db: db}, nil
+}
+
+func (s *SQLiteSettingsStorage) GetSettings(chatID int64) (*domain.ChatSettings, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM chat_settings WHERE chat_id = ?`, chatID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	var settings domain.ChatSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+func (s *SQLiteSettingsStorage) SaveSettings(settings *domain.ChatSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal settings: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO chat_settings (chat_id, data) VALUES (?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET data = excluded.data`,
+		settings.ChatID, data)
+	if err != nil {
+		return fmt.Errorf("failed to save settings: %w", err)
+	}
+
+	return nil
+}
@@ -2,9 +2,11 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"strconv"
 	"strings"
+	"time"
 
 	tb "gopkg.in/telebot.v4"
 )
@@ -71,3 +73,75 @@ func BotCanMute(botID int64, admins []tb.ChatMember) bool {
 
 	return false
 }
+
+var ErrInvalidDuration = errors.New("provided duration is not a sequence of numbers with m/h/d/w units")
+
+var durationUnits = map[byte]time.Duration{
+	'm': time.Minute,
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parses durations like 30m, 3h, 1d or 1w2d, unlike time.ParseDuration it knows days and weeks
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, ErrInvalidDuration
+	}
+
+	var total time.Duration
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, ErrInvalidDuration
+		}
+
+		unit, ok := durationUnits[s[i]]
+		if !ok {
+			return 0, ErrInvalidDuration
+		}
+
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, ErrInvalidDuration
+		}
+
+		total += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+
+	return total, nil
+}
+
+// formats a duration the way ParseDuration reads it, e.g. 1d12h
+func FormatDuration(d time.Duration) string {
+	var sb strings.Builder
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"d", 24 * time.Hour},
+		{"h", time.Hour},
+		{"m", time.Minute},
+	} {
+		if n := d / unit.size; n > 0 {
+			fmt.Fprintf(&sb, "%d%s", n, unit.suffix)
+			d -= n * unit.size
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "0m"
+	}
+	return sb.String()
+}
+
+// for time left to wait, rounded up to a minute so a wait that is left
+// never reads as 0m. nothing left to wait is 0m
+func FormatWait(wait time.Duration) string {
+	return FormatDuration((max(wait, 0) + time.Minute - 1).Truncate(time.Minute))
+}
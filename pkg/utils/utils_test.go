@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30s", 0, true},
+		{"30m", 30 * time.Minute, false},
+		{"3h", 3 * time.Hour, false},
+		{"1d", 24 * time.Hour, false},
+		{"1w2d", 9 * 24 * time.Hour, false},
+		{" 1D12H ", 36 * time.Hour, false},
+		{"", 0, true},
+		{"10", 0, true},
+		{"d", 0, true},
+		{"1x", 0, true},
+		{"1h30", 0, true},
+		{"-1h", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDuration(tt.in)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidDuration) {
+					t.Errorf("ParseDuration(%q) error = %v, want ErrInvalidDuration", tt.in, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseDuration(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{0, "0m"},
+		{30 * time.Second, "0m"},
+		{90 * time.Minute, "1h30m"},
+		{36 * time.Hour, "1d12h"},
+		{24*time.Hour + time.Minute, "1d1m"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatDuration(tt.in); got != tt.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatWait(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{-time.Minute, "0m"},
+		{time.Second, "1m"},
+		{time.Minute, "1m"},
+		{time.Minute + time.Second, "2m"},
+		{59*time.Minute + 30*time.Second, "1h"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatWait(tt.in); got != tt.want {
+			t.Errorf("FormatWait(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}